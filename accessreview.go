@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// accessReviewer checks, through SubjectAccessReviews, whether a pod's service
+// account may get a service. Decisions are cached for a TTL because every
+// candidate service costs an API call.
+type accessReviewer struct {
+	// reviews is nil until the client is ready; tests can set it to a fake.
+	reviews authorizationv1client.SubjectAccessReviewInterface
+	// clock is nil for the real clock; tests can set it to a fake.
+	clock clock
+
+	mu      sync.Mutex
+	entries map[string]accessReviewEntry
+}
+
+type accessReviewEntry struct {
+	allowed bool
+	expires time.Time
+}
+
+var serviceAccess = &accessReviewer{}
+
+// podServiceAccount returns the name of the service account the pod runs as.
+func podServiceAccount(pod *corev1.Pod) string {
+	if pod.Spec.ServiceAccountName != "" {
+		return pod.Spec.ServiceAccountName
+	}
+	return "default"
+}
+
+// allowed reports whether the service account in namespace may get the
+// service.
+func (r *accessReviewer) allowed(ctx context.Context, namespace, serviceAccount string, service *corev1.Service) (bool, error) {
+	key := namespace + "/" + serviceAccount + "|" + service.GetNamespace() + "/" + service.GetName()
+
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	r.mu.Unlock()
+	if ok && clockOrReal(r.clock).Now().Before(entry.expires) {
+		return entry.allowed, nil
+	}
+
+	review, err := r.reviews.Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   "system:serviceaccount:" + namespace + ":" + serviceAccount,
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: service.GetNamespace(),
+				Verb:      "get",
+				Resource:  "services",
+				Name:      service.GetName(),
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == nil {
+		r.entries = make(map[string]accessReviewEntry)
+	}
+	r.entries[key] = accessReviewEntry{
+		allowed: review.Status.Allowed,
+		expires: clockOrReal(r.clock).Now().Add(conf.AccessReviewCacheTTL),
+	}
+	return review.Status.Allowed, nil
+}
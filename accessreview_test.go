@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeReviews is a SubjectAccessReview client allowing the services named in
+// allowed. It records the reviews it answers and how many were in flight at
+// most.
+type fakeReviews struct {
+	allowed []string
+	delay   time.Duration
+
+	mu          sync.Mutex
+	users       []string
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *fakeReviews) Create(_ context.Context, review *authorizationv1.SubjectAccessReview, _ metav1.CreateOptions) (*authorizationv1.SubjectAccessReview, error) {
+	f.mu.Lock()
+	f.users = append(f.users, review.Spec.User)
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+	review = review.DeepCopy()
+	review.Status.Allowed = slices.Contains(f.allowed, review.Spec.ResourceAttributes.Name)
+	return review, nil
+}
+
+// setupAccessReview makes alias builds review service access through reviews,
+// restoring the shared reviewer when the test ends.
+func setupAccessReview(t *testing.T, reviews *fakeReviews) {
+	t.Helper()
+	saved := serviceAccess
+	t.Cleanup(func() { serviceAccess = saved })
+	serviceAccess = &accessReviewer{reviews: reviews}
+	conf.ServiceAccessReview = true
+}
+
+func TestBuildHostAliasesReviewsServiceAccess(t *testing.T) {
+	setupMutation(t,
+		testService("default", "a", "10.0.0.1"),
+		testService("default", "b", "10.0.0.2"),
+		testService("default", "c", "10.0.0.3"),
+	)
+	reviews := &fakeReviews{allowed: []string{"a", "c"}}
+	setupAccessReview(t, reviews)
+
+	pod := testPod("default")
+	pod.Spec.ServiceAccountName = "app"
+	if got, want := builtServices(t, pod), []string{"default/a", "default/c"}; !slices.Equal(got, want) {
+		t.Fatalf("services = %q, want %q", got, want)
+	}
+	if len(reviews.users) != 3 || reviews.users[0] != "system:serviceaccount:default:app" {
+		t.Fatalf("reviews were issued for %q, want three for the pod's service account", reviews.users)
+	}
+
+	// Decisions are cached, so a second build issues no reviews.
+	builtServices(t, pod)
+	if len(reviews.users) != 3 {
+		t.Fatalf("%d reviews issued after a second build, want the 3 cached ones", len(reviews.users))
+	}
+}
+
+func TestDeniedServicesBoundsConcurrency(t *testing.T) {
+	var services []*corev1.Service
+	for i := 0; i < 3*maxConcurrentAccessReviews; i++ {
+		services = append(services, testService("default", fmt.Sprintf("svc%02d", i), fmt.Sprintf("10.0.1.%d", i+1)))
+	}
+	setupMutation(t, services...)
+	reviews := &fakeReviews{delay: 5 * time.Millisecond}
+	setupAccessReview(t, reviews)
+
+	denied, err := deniedServices(context.Background(), aliasScope{PodNamespace: "default", ServiceAccount: "app"}, services)
+	if err != nil {
+		t.Fatalf("reviewing access: %v", err)
+	}
+	if len(denied) != len(services) {
+		t.Fatalf("%d services denied, want all %d", len(denied), len(services))
+	}
+	if reviews.maxInFlight < 2 || reviews.maxInFlight > maxConcurrentAccessReviews {
+		t.Fatalf("%d reviews in flight at most, want between 2 and %d", reviews.maxInFlight, maxConcurrentAccessReviews)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aliasCache holds assembled host aliases per scope for a short TTL, so bursts
+// of admissions do not rebuild the same list. It is cleared whenever the
+// service informer reports a change.
+type aliasCache struct {
+	// clock is nil for the real clock; tests can set it to a fake.
+	clock clock
+
+	mu      sync.Mutex
+	entries map[string]aliasCacheEntry
+}
+
+type aliasCacheEntry struct {
+	result  hostAliasResult
+	expires time.Time
+}
+
+var hostAliasCache = &aliasCache{}
+
+// key identifies everything about a scope that affects the built aliases.
+func (s aliasScope) key() string {
+	return strings.Join(s.Namespaces, ",") + "|" + s.PodNamespace + "|" + strconv.FormatBool(s.FromPod) + "|" + s.ServiceAccount +
+		"|" + strings.Join(s.OwnServices, ",")
+}
+
+// get returns the cached result for key if it has not expired. The result is
+// shared and must not be modified.
+func (c *aliasCache) get(key string) (hostAliasResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return hostAliasResult{}, false
+	}
+	if !clockOrReal(c.clock).Now().Before(entry.expires) {
+		delete(c.entries, key)
+		return hostAliasResult{}, false
+	}
+	return entry.result, true
+}
+
+// set caches result for key. It also drops expired entries, so keys that are
+// never looked up again do not accumulate between invalidations.
+func (c *aliasCache) set(key string, result hostAliasResult, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := clockOrReal(c.clock).Now()
+	if c.entries == nil {
+		c.entries = make(map[string]aliasCacheEntry)
+	}
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = aliasCacheEntry{
+		result:  result,
+		expires: now.Add(ttl),
+	}
+}
+
+// invalidate drops every cached entry.
+func (c *aliasCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestAliasCacheSetSweepsExpiredEntries(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := &aliasCache{clock: clock}
+
+	c.set("old", hostAliasResult{}, time.Second)
+	clock.now = clock.now.Add(2 * time.Second)
+	c.set("new", hostAliasResult{}, time.Second)
+
+	if _, ok := c.entries["old"]; ok {
+		t.Fatal("expired entry was not swept")
+	}
+	if _, ok := c.get("new"); !ok {
+		t.Fatal("fresh entry missing")
+	}
+}
+
+func TestAliasCacheGetExpires(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := &aliasCache{clock: clock}
+	c.set("key", hostAliasResult{Warnings: []string{"cached"}}, time.Second)
+
+	clock.now = clock.now.Add(time.Second - time.Nanosecond)
+	if result, ok := c.get("key"); !ok || len(result.Warnings) != 1 {
+		t.Fatalf("get before the TTL = %+v, %v, want the cached result", result, ok)
+	}
+	clock.now = clock.now.Add(time.Nanosecond)
+	if _, ok := c.get("key"); ok {
+		t.Fatal("get returned an entry at its TTL")
+	}
+	if _, ok := c.entries["key"]; ok {
+		t.Fatal("expired entry was not dropped by get")
+	}
+}
+
+// BenchmarkAliasCache compares building host aliases for every pod with
+// serving them from the alias cache.
+func BenchmarkAliasCache(b *testing.B) {
+	services := make([]*corev1.Service, 0, 200)
+	for i := range 200 {
+		services = append(services, testService(fmt.Sprintf("ns-%d", i%10), fmt.Sprintf("svc-%d", i), fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)))
+	}
+	setupMutation(b, services...)
+	conf.ServiceScope = serviceScopeCluster
+	ctx := context.Background()
+	scope := podAliasScope(testPod("default"), "default")
+
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		b.Run("ttl "+ttl.String(), func(b *testing.B) {
+			conf.AliasCacheTTL = ttl
+			hostAliasCache.invalidate()
+			for range b.N {
+				if _, err := getHostAliasesFromServices(ctx, serviceLister, scope); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,807 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// aliasScope selects the services that contribute host aliases to one pod.
+type aliasScope struct {
+	// Namespaces lists the namespaces to read services from; empty means all
+	// namespaces.
+	Namespaces []string
+	// PodNamespace is the namespace of the pod being admitted. Its services
+	// are preferred when the number of host aliases is limited.
+	PodNamespace string
+	// FromPod is set when the pod chose Namespaces through its namespaces
+	// annotation. Such a choice replaces the service scope, but the
+	// namespace allowlist and denylist still apply.
+	FromPod bool
+	// ServiceAccount is the pod's service account, set when services are
+	// filtered by access review.
+	ServiceAccount string
+	// OwnLabels and OwnServices are the pod's labels and the services its
+	// own-services annotation names, set when its own services are excluded.
+	// resolveOwnServices folds the services selecting OwnLabels into
+	// OwnServices before aliases are built, so only the names remain.
+	OwnLabels   labels.Set
+	OwnServices []string
+}
+
+// podAliasScope derives the alias scope for a pod being admitted into
+// namespace. The pod's namespaces annotation, when present, overrides the
+// configured service scope.
+func podAliasScope(pod *corev1.Pod, namespace string) aliasScope {
+	scope := aliasScope{PodNamespace: namespace}
+	if v, ok := pod.GetAnnotations()[conf.PodNamespacesAnnotation]; ok {
+		scope.Namespaces = splitList(v)
+		scope.FromPod = true
+	} else if conf.ServiceScope == serviceScopeNamespace {
+		scope.Namespaces = []string{namespace}
+	}
+	if conf.ServiceAccessReview {
+		scope.ServiceAccount = podServiceAccount(pod)
+	}
+	if conf.ExcludeOwnServices {
+		scope.OwnLabels = labels.Set(pod.GetLabels())
+		scope.OwnServices = splitList(pod.GetAnnotations()[conf.PodOwnServicesAnnotation])
+	}
+	return scope
+}
+
+// resolveOwnServices returns scope with OwnServices extended by the services
+// in the pod's namespace that select OwnLabels, sorted, and OwnLabels cleared.
+// Pods of one workload then share a scope key, whatever other labels they
+// carry.
+func resolveOwnServices(lister corelisters.ServiceLister, scope aliasScope) (aliasScope, error) {
+	if len(scope.OwnLabels) == 0 {
+		return scope, nil
+	}
+	services, err := lister.Services(scope.PodNamespace).List(labels.Everything())
+	if err != nil {
+		return scope, err
+	}
+	own := slices.Clone(scope.OwnServices)
+	for _, service := range services {
+		selector := service.Spec.Selector
+		if len(selector) > 0 && labels.SelectorFromSet(selector).Matches(scope.OwnLabels) {
+			own = append(own, service.GetName())
+		}
+	}
+	slices.Sort(own)
+	scope.OwnServices = slices.Compact(own)
+	scope.OwnLabels = nil
+	return scope, nil
+}
+
+// isOwnService reports whether service is one of the pod's own services, as
+// resolved by resolveOwnServices.
+func isOwnService(service *corev1.Service, scope aliasScope) bool {
+	return service.GetNamespace() == scope.PodNamespace && slices.Contains(scope.OwnServices, service.GetName())
+}
+
+// podInjectionStrategy returns the injection strategy chosen by the pod's
+// strategy annotation, or the configured one when it has none. An unknown
+// value also yields the configured strategy, along with an error describing
+// it.
+func podInjectionStrategy(pod *corev1.Pod) (string, error) {
+	v, ok := pod.GetAnnotations()[conf.PodStrategyAnnotation]
+	if !ok {
+		return conf.InjectionStrategy, nil
+	}
+	switch strategy := strings.ToLower(strings.TrimSpace(v)); strategy {
+	case injectionStrategyAppend, injectionStrategyPrepend, injectionStrategyOverwrite:
+		return strategy, nil
+	default:
+		return conf.InjectionStrategy, fmt.Errorf("unknown injection strategy %q", v)
+	}
+}
+
+// sortServices orders services from the pod's own namespace first, then by
+// namespace and name, so the most relevant services survive truncation.
+func sortServices(services []*corev1.Service, podNamespace string) {
+	slices.SortFunc(services, func(a, b *corev1.Service) int {
+		aLocal, bLocal := a.GetNamespace() == podNamespace, b.GetNamespace() == podNamespace
+		if aLocal != bLocal {
+			if aLocal {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(a.GetNamespace(), b.GetNamespace()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+}
+
+// listServices returns the cached services in the scope's namespaces, or in
+// all namespaces when the configured scope names none. An empty namespaces
+// annotation on the pod selects no services.
+func listServices(lister corelisters.ServiceLister, scope aliasScope) ([]*corev1.Service, error) {
+	if len(scope.Namespaces) == 0 && !scope.FromPod {
+		return lister.List(labels.Everything())
+	}
+
+	var services []*corev1.Service
+	for _, namespace := range scope.Namespaces {
+		s, err := lister.Services(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, s...)
+	}
+	return services, nil
+}
+
+// annotationEnabled reports whether the annotation key is set to a true
+// boolean value such as "true".
+func annotationEnabled(annotations map[string]string, key string) bool {
+	v, ok := annotations[key]
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(v))
+	return err == nil && enabled
+}
+
+// serviceNamespaceAllowed reports whether services in namespace may contribute
+// host aliases. A namespace on the denylist is always rejected, even when it
+// is also on the allowlist; an empty allowlist allows every other namespace.
+// Namespaces a pod chooses through its annotation are held to both lists, so
+// pods cannot reach past what the operator allows.
+func serviceNamespaceAllowed(namespace string) bool {
+	if slices.Contains(conf.DeniedServiceNamespaces, namespace) {
+		return false
+	}
+	return len(conf.AllowedServiceNamespaces) == 0 || slices.Contains(conf.AllowedServiceNamespaces, namespace)
+}
+
+// rawClusterIPs returns the service's assigned cluster IP values, which may
+// not parse.
+func rawClusterIPs(service *corev1.Service) []string {
+	ips := service.Spec.ClusterIPs
+	if len(ips) == 0 {
+		// ClusterIPs is only populated by API servers that know about dual-stack.
+		ips = []string{service.Spec.ClusterIP}
+	}
+
+	assigned := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if ip == "" || ip == corev1.ClusterIPNone {
+			continue
+		}
+		assigned = append(assigned, ip)
+	}
+	return assigned
+}
+
+// serviceClusterIPs returns the service's cluster IPs, one per IP family on
+// dual-stack clusters. Headless and unallocated services have none, and
+// values that are not valid IPs are left out so they never become a broken
+// /etc/hosts line.
+func serviceClusterIPs(service *corev1.Service) []string {
+	ips := rawClusterIPs(service)
+	clusterIPs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if _, err := netip.ParseAddr(ip); err == nil {
+			clusterIPs = append(clusterIPs, ip)
+		}
+	}
+	return clusterIPs
+}
+
+// serviceExternalIPs returns the service's valid external IPs when external IP
+// aliases are enabled.
+func serviceExternalIPs(service *corev1.Service) []string {
+	if !conf.ExternalIPAliases {
+		return nil
+	}
+	externalIPs := make([]string, 0, len(service.Spec.ExternalIPs))
+	for _, ip := range service.Spec.ExternalIPs {
+		if _, err := netip.ParseAddr(ip); err == nil {
+			externalIPs = appendMissing(externalIPs, ip)
+		}
+	}
+	return externalIPs
+}
+
+// serviceHostnames returns the configured hostname forms for a service, or
+// those of its forms annotation, in order, followed by one hostname per extra
+// domain suffix and any hostnames from the hostname template, all normalized.
+func serviceHostnames(service *corev1.Service) []string {
+	name, namespace := service.GetName(), service.GetNamespace()
+	forms := conf.HostnameForms
+	if v, ok := service.GetAnnotations()[conf.ServiceFormsAnnotation]; ok {
+		if override, err := parseHostnameForms(splitList(v)); err != nil {
+			slog.Warn("ignoring invalid hostname forms annotation", "service", namespace+"/"+name,
+				"annotation", conf.ServiceFormsAnnotation, "error", err)
+		} else {
+			forms = override
+		}
+	}
+	hostnames := make([]string, 0, len(forms))
+	for _, form := range forms {
+		switch form {
+		case hostnameFormFQDN:
+			hostnames = append(hostnames, fmt.Sprintf("%s.%s.%s.%s", name, namespace, conf.ServiceDomain, conf.ClusterDomain))
+		case hostnameFormSvc:
+			hostnames = append(hostnames, fmt.Sprintf("%s.%s.%s", name, namespace, conf.ServiceDomain))
+		case hostnameFormShort:
+			hostnames = append(hostnames, fmt.Sprintf("%s.%s", name, namespace))
+		}
+	}
+	for _, suffix := range conf.ExtraDomainSuffixes {
+		hostnames = appendMissing(hostnames, fmt.Sprintf("%s.%s.%s", name, namespace, suffix))
+	}
+
+	if conf.hostnameTemplate != nil {
+		extra, err := executeHostnameTemplate(conf.hostnameTemplate, hostnameTemplateData{
+			Name:      name,
+			Namespace: namespace,
+			ClusterIP: service.Spec.ClusterIP,
+		})
+		if err != nil {
+			slog.Warn("failed to execute hostname template", "service", namespace+"/"+name, "error", err)
+		}
+		hostnames = appendMissing(hostnames, extra...)
+	}
+	return normalizeHostnames(hostnames)
+}
+
+// normalizeHostnames lowercases hostnames and trims trailing dots, since some
+// resolvers match /etc/hosts entries case-sensitively, and drops the
+// duplicates this creates.
+func normalizeHostnames(hostnames []string) []string {
+	normalized := make([]string, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		if hostname = strings.ToLower(strings.TrimRight(hostname, ".")); hostname != "" {
+			normalized = appendMissing(normalized, hostname)
+		}
+	}
+	return normalized
+}
+
+// hostAliasResult is what getHostAliasesFromServices builds for one pod.
+type hostAliasResult struct {
+	HostAliases []corev1.HostAlias
+	// Services are the services that contributed host aliases.
+	Services []types.NamespacedName
+	// Owners maps each injected hostname to the service it came from.
+	Owners map[string]types.NamespacedName
+	// Warnings are returned to the client with the admission response.
+	Warnings []string
+}
+
+// Reasons a service does not contribute host aliases.
+const (
+	skipReasonNamespace    = "namespace"
+	skipReasonAnnotation   = "annotation"
+	skipReasonExternalName = "external_name"
+	skipReasonType         = "type"
+	skipReasonNoClusterIP  = "no_cluster_ip"
+	skipReasonInvalidIP    = "invalid_cluster_ip"
+	skipReasonNoEndpoints  = "no_ready_endpoints"
+	skipReasonLimit        = "limit"
+	skipReasonDuplicate    = "duplicate"
+	skipReasonAccess       = "access"
+	skipReasonNoPorts      = "no_ports"
+	skipReasonDenied       = "denied"
+	skipReasonOwnService   = "own_service"
+	skipReasonInvalidName  = "invalid_hostname"
+)
+
+// serviceTypeAllowed reports whether services of type t may contribute host
+// aliases. NodePort and LoadBalancer services also have a cluster IP, so they
+// can be enabled alongside ClusterIP.
+func serviceTypeAllowed(t corev1.ServiceType) bool {
+	if t == "" {
+		t = corev1.ServiceTypeClusterIP
+	}
+	return slices.Contains(conf.ServiceTypes, string(t))
+}
+
+// serviceSkipReason returns why service must not contribute host aliases to a
+// pod in scope, or "" if it may.
+func serviceSkipReason(service *corev1.Service, scope aliasScope) string {
+	switch {
+	case !serviceNamespaceAllowed(service.GetNamespace()):
+		return skipReasonNamespace
+	case slices.Contains(conf.DeniedServices, service.GetNamespace()+"/"+service.GetName()):
+		return skipReasonDenied
+	case isOwnService(service, scope):
+		return skipReasonOwnService
+	case annotationEnabled(service.GetAnnotations(), conf.ServiceSkipAnnotation):
+		return skipReasonAnnotation
+	case service.Spec.Type == corev1.ServiceTypeExternalName:
+		// ExternalName services are CNAMEs with no cluster IP to map.
+		return skipReasonExternalName
+	case !serviceTypeAllowed(service.Spec.Type):
+		return skipReasonType
+	case conf.RequireServicePorts && len(service.Spec.Ports) == 0:
+		return skipReasonNoPorts
+	case useEndpointAddresses(service), conf.HeadlessEndpoints && isHeadless(service):
+		// Resolved to endpoint addresses instead of a cluster IP.
+		return ""
+	case len(serviceExternalIPs(service)) > 0:
+		return ""
+	case len(serviceClusterIPs(service)) == 0:
+		if len(rawClusterIPs(service)) > 0 {
+			return skipReasonInvalidIP
+		}
+		return skipReasonNoClusterIP
+	default:
+		return ""
+	}
+}
+
+// getHostAliasesFromServices returns host aliases for the services in scope,
+// served from the alias cache while it is fresh. The webhook passes the shared
+// informer's serviceLister; tests can pass a lister over a fake clientset.
+func getHostAliasesFromServices(ctx context.Context, lister corelisters.ServiceLister, scope aliasScope) (hostAliasResult, error) {
+	scope, err := resolveOwnServices(lister, scope)
+	if err != nil {
+		return hostAliasResult{}, err
+	}
+	if conf.AliasCacheTTL <= 0 {
+		return limitedBuildHostAliases(ctx, lister, scope)
+	}
+
+	key := scope.key()
+	if result, ok := hostAliasCache.get(key); ok {
+		return result, nil
+	}
+	result, err := limitedBuildHostAliases(ctx, lister, scope)
+	if err != nil {
+		return result, err
+	}
+	hostAliasCache.set(key, result, conf.AliasCacheTTL)
+	return result, nil
+}
+
+// limitedBuildHostAliases calls buildHostAliases once a concurrency slot is
+// free, waiting at most until ctx is done.
+func limitedBuildHostAliases(ctx context.Context, lister corelisters.ServiceLister, scope aliasScope) (hostAliasResult, error) {
+	if err := aliasBuilds.acquire(ctx); err != nil {
+		return hostAliasResult{}, err
+	}
+	defer aliasBuilds.release()
+	return buildHostAliases(ctx, lister, scope)
+}
+
+// buildHostAliases builds host aliases for the services in scope.
+func buildHostAliases(ctx context.Context, lister corelisters.ServiceLister, scope aliasScope) (hostAliasResult, error) {
+	var result hostAliasResult
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	services, err := listServices(lister, scope)
+	if err != nil {
+		return result, err
+	}
+
+	if len(services) == 0 {
+		return result, nil
+	}
+
+	// The lister returns a slice owned by the caller, so sorting it is safe.
+	sortServices(services, scope.PodNamespace)
+
+	hostAliases := make([]corev1.HostAlias, 0)
+	// externalAliases come after every other entry. The first /etc/hosts
+	// line for a name wins, so the cluster IP stays in use in the cluster.
+	var externalAliases []corev1.HostAlias
+	omitted := 0
+	// claimed records which service owns each hostname. A hostname can only
+	// map to one service in /etc/hosts, so later services lose collisions.
+	claimed := make(map[string]types.NamespacedName)
+
+	skipped := make(map[string]int)
+
+	candidates := make([]*corev1.Service, 0, len(services))
+	for _, service := range services {
+		if reason := serviceSkipReason(service, scope); reason != "" {
+			if reason == skipReasonInvalidIP {
+				slog.Warn("skipping service with an invalid cluster IP", "service", service.GetNamespace()+"/"+service.GetName(),
+					"clusterIPs", rawClusterIPs(service))
+			}
+			skipped[reason]++
+			continue
+		}
+		candidates = append(candidates, service)
+	}
+	denied, err := deniedServices(ctx, scope, candidates)
+	if err != nil {
+		return hostAliasResult{}, err
+	}
+
+	for _, service := range candidates {
+		if err := ctx.Err(); err != nil {
+			return hostAliasResult{}, err
+		}
+		if denied[service] {
+			skipped[skipReasonAccess]++
+			continue
+		}
+		clusterIPs := serviceClusterIPs(service)
+		var endpoints []serviceEndpoint
+		switch {
+		case useEndpointAddresses(service):
+			if endpoints, err = readyEndpoints(service); err != nil {
+				return hostAliasResult{}, fmt.Errorf("endpoints of service %s/%s: %w", service.GetNamespace(), service.GetName(), err)
+			}
+			if len(endpoints) == 0 {
+				skipped[skipReasonNoEndpoints]++
+				continue
+			}
+			clusterIPs = nil
+		case conf.HeadlessEndpoints && isHeadless(service):
+			if clusterIPs, err = headlessServiceIPs(service); err != nil {
+				return hostAliasResult{}, fmt.Errorf("endpoints of service %s/%s: %w", service.GetNamespace(), service.GetName(), err)
+			}
+			if len(clusterIPs) == 0 {
+				skipped[skipReasonNoEndpoints]++
+				continue
+			}
+		case conf.RequireReadyEndpoints:
+			ready, err := readyEndpoints(service)
+			if err != nil {
+				return hostAliasResult{}, fmt.Errorf("endpoints of service %s/%s: %w", service.GetNamespace(), service.GetName(), err)
+			}
+			if len(ready) == 0 {
+				skipped[skipReasonNoEndpoints]++
+				continue
+			}
+		}
+
+		externalIPs := serviceExternalIPs(service)
+		if conf.MaxHostAliases > 0 && len(hostAliases)+len(clusterIPs)+len(endpoints)+len(externalIPs) > conf.MaxHostAliases {
+			skipped[skipReasonLimit]++
+			omitted++
+			continue
+		}
+
+		key := types.NamespacedName{Namespace: service.GetNamespace(), Name: service.GetName()}
+		hostnames := make([]string, 0)
+		invalid := 0
+		generated := serviceHostnames(service)
+		for _, hostname := range generated {
+			// A hostname that is not a valid RFC 1123 name, e.g. one too long
+			// or produced by a bad template, would be a broken /etc/hosts line.
+			if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+				invalid++
+				slog.Warn("dropping invalid hostname", "hostname", hostname, "service", key.String(), "errors", errs)
+				result.Warnings = append(result.Warnings, fmt.Sprintf(
+					"host-injector: hostname %q of service %s is not a valid RFC 1123 name, dropped", hostname, key))
+				continue
+			}
+			if owner, ok := claimed[hostname]; ok && owner != key {
+				slog.Warn("dropping duplicate hostname", "hostname", hostname, "service", key.String(), "owner", owner.String())
+				result.Warnings = append(result.Warnings, fmt.Sprintf(
+					"host-injector: hostname %q of service %s already maps to service %s, dropped", hostname, key, owner))
+				continue
+			}
+			claimed[hostname] = key
+			hostnames = append(hostnames, hostname)
+		}
+		if len(hostnames) == 0 {
+			if invalid == len(generated) {
+				skipped[skipReasonInvalidName]++
+			} else {
+				skipped[skipReasonDuplicate]++
+			}
+			continue
+		}
+
+		serviceAliases := endpointHostAliases(endpoints, hostnames)
+		for _, ip := range clusterIPs {
+			serviceAliases = append(serviceAliases, corev1.HostAlias{
+				IP:        ip,
+				Hostnames: slices.Clone(hostnames),
+			})
+		}
+		serviceExternalAliases := make([]corev1.HostAlias, 0, len(externalIPs))
+		for _, ip := range externalIPs {
+			serviceExternalAliases = append(serviceExternalAliases, corev1.HostAlias{
+				IP:        ip,
+				Hostnames: slices.Clone(hostnames),
+			})
+		}
+		if conf.LocalServicesFirst {
+			sortHostAliases(serviceAliases)
+			sortHostAliases(serviceExternalAliases)
+			serviceAliases = append(serviceAliases, serviceExternalAliases...)
+		} else {
+			externalAliases = append(externalAliases, serviceExternalAliases...)
+		}
+		hostAliases = append(hostAliases, serviceAliases...)
+		result.Services = append(result.Services, key)
+	}
+
+	if len(skipped) > 0 {
+		attrs := make([]any, 0, 2*len(skipped))
+		for reason, n := range skipped {
+			servicesSkippedTotal.WithLabelValues(reason).Add(float64(n))
+			attrs = append(attrs, reason, n)
+		}
+		slog.Debug("skipped services", slog.Group("reasons", attrs...))
+	}
+
+	if omitted > 0 {
+		slog.Warn("host aliases truncated", "limit", conf.MaxHostAliases, "omittedServices", omitted)
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"host-injector: host aliases truncated to %d entries, %d services omitted", conf.MaxHostAliases, omitted))
+	}
+
+	// Services are already in order, so only the aliases of each service
+	// were sorted above.
+	if !conf.LocalServicesFirst {
+		sortHostAliases(hostAliases)
+		sortHostAliases(externalAliases)
+		hostAliases = append(hostAliases, externalAliases...)
+	}
+	result.HostAliases = hostAliases
+	result.Owners = claimed
+	return result, nil
+}
+
+// maxConcurrentAccessReviews bounds the SubjectAccessReviews a single alias
+// build has in flight.
+const maxConcurrentAccessReviews = 16
+
+// deniedServices returns the services the scope's service account may not
+// get, or none when access is not reviewed. The reviews run concurrently, up
+// to maxConcurrentAccessReviews at once, so a cold review cache over many
+// services fits in the admission timeout.
+func deniedServices(ctx context.Context, scope aliasScope, services []*corev1.Service) (map[*corev1.Service]bool, error) {
+	if scope.ServiceAccount == "" {
+		return nil, nil
+	}
+
+	allowed := make([]bool, len(services))
+	errs := make([]error, len(services))
+	slots := make(chan struct{}, maxConcurrentAccessReviews)
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			allowed[i], errs[i] = serviceAccess.allowed(ctx, scope.PodNamespace, scope.ServiceAccount, service)
+		}()
+	}
+	wg.Wait()
+
+	denied := make(map[*corev1.Service]bool)
+	for i, service := range services {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("access review for service %s/%s: %w", service.GetNamespace(), service.GetName(), errs[i])
+		}
+		if !allowed[i] {
+			denied[service] = true
+		}
+	}
+	return denied, nil
+}
+
+// servicesOf returns the services, in order, that own a hostname in
+// hostAliases, a subset of r.HostAliases.
+func (r hostAliasResult) servicesOf(hostAliases []corev1.HostAlias) []types.NamespacedName {
+	owners := make(map[types.NamespacedName]bool)
+	for _, hostAlias := range hostAliases {
+		for _, hostname := range hostAlias.Hostnames {
+			if owner, ok := r.Owners[hostname]; ok {
+				owners[owner] = true
+			}
+		}
+	}
+	services := make([]types.NamespacedName, 0, len(owners))
+	for _, service := range r.Services {
+		if owners[service] {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// sortHostAliases orders host aliases by IP, then by first hostname, so the
+// same services always produce the same patch.
+func sortHostAliases(hostAliases []corev1.HostAlias) {
+	slices.SortStableFunc(hostAliases, func(a, b corev1.HostAlias) int {
+		if c := compareIPs(a.IP, b.IP); c != 0 {
+			return c
+		}
+		return slices.Compare(a.Hostnames, b.Hostnames)
+	})
+}
+
+// compareIPs orders IPs numerically, IPv4 before IPv6. Unparsable values sort
+// after valid ones, by string.
+func compareIPs(a, b string) int {
+	aAddr, aErr := netip.ParseAddr(a)
+	bAddr, bErr := netip.ParseAddr(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return aAddr.Compare(bAddr)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// The API server rejects pods with more DNS search domains, or a longer
+// space-separated search list, than these.
+const (
+	maxDNSSearches        = 32
+	maxDNSSearchListChars = 2048
+)
+
+// injectDNSSearches adds a search domain for each namespace the services come
+// from, so their short names resolve through cluster DNS. Domains that would
+// push the pod past the API server's limits are left out; it returns how many.
+func injectDNSSearches(pod *corev1.Pod, services []types.NamespacedName) int {
+	var existing []string
+	if pod.Spec.DNSConfig != nil {
+		existing = pod.Spec.DNSConfig.Searches
+	}
+	listLen := len(strings.Join(existing, " "))
+	count := len(existing)
+
+	// Services are ordered with the pod's namespace first, so those are kept.
+	var searches []string
+	omitted := 0
+	for _, service := range services {
+		search := fmt.Sprintf("%s.%s.%s", service.Namespace, conf.ServiceDomain, conf.ClusterDomain)
+		if slices.Contains(existing, search) || slices.Contains(searches, search) {
+			continue
+		}
+		n := listLen + len(search)
+		if count > 0 {
+			n++
+		}
+		if count >= maxDNSSearches || n > maxDNSSearchListChars {
+			omitted++
+			continue
+		}
+		searches = append(searches, search)
+		listLen, count = n, count+1
+	}
+	if len(searches) == 0 {
+		return omitted
+	}
+
+	if pod.Spec.DNSConfig == nil {
+		pod.Spec.DNSConfig = &corev1.PodDNSConfig{}
+	}
+	pod.Spec.DNSConfig.Searches = append(pod.Spec.DNSConfig.Searches, searches...)
+	return omitted
+}
+
+// mergeHostAliases adds the injected aliases to the existing ones. Injected
+// hostnames for an IP that is already present are merged into that entry
+// instead of adding a second one. A hostname the pod already declares is never
+// injected again, whatever its IP, so user-declared mappings win and merging
+// the same set twice is a no-op.
+//
+// For the prepend strategy, call it with the arguments swapped: the injected
+// aliases then come first and win over hostnames the pod declares.
+func mergeHostAliases(existing, injected []corev1.HostAlias) []corev1.HostAlias {
+	merged := make([]corev1.HostAlias, 0, len(existing)+len(injected))
+	byIP := make(map[string]int, len(existing)+len(injected))
+	declared := make(map[string]bool)
+	for _, hostAlias := range existing {
+		if _, ok := byIP[hostAlias.IP]; !ok {
+			byIP[hostAlias.IP] = len(merged)
+		}
+		for _, hostname := range hostAlias.Hostnames {
+			declared[hostname] = true
+		}
+		hostAlias.Hostnames = slices.Clone(hostAlias.Hostnames)
+		merged = append(merged, hostAlias)
+	}
+
+	for _, hostAlias := range injected {
+		hostnames := make([]string, 0, len(hostAlias.Hostnames))
+		for _, hostname := range hostAlias.Hostnames {
+			if !declared[hostname] {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+		if len(hostnames) == 0 {
+			continue
+		}
+
+		if i, ok := byIP[hostAlias.IP]; ok {
+			merged[i].Hostnames = appendMissing(merged[i].Hostnames, hostnames...)
+			continue
+		}
+		byIP[hostAlias.IP] = len(merged)
+		merged = append(merged, corev1.HostAlias{
+			IP:        hostAlias.IP,
+			Hostnames: hostnames,
+		})
+	}
+	return merged
+}
+
+// shadowsClusterDNS reports whether any of hostAliases maps a name under the
+// cluster's service domain, which /etc/hosts then answers instead of cluster
+// DNS.
+func shadowsClusterDNS(hostAliases []corev1.HostAlias) bool {
+	// Hostnames are lowercased when built, the cluster domain is not.
+	suffix := "." + conf.ServiceDomain + "." + strings.ToLower(conf.ClusterDomain)
+	for _, hostAlias := range hostAliases {
+		for _, hostname := range hostAlias.Hostnames {
+			if strings.HasSuffix(hostname, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// capHostnames returns hostAliases with at most limit hostnames per IP,
+// counted across entries, so a single IP never gets an unwieldy /etc/hosts
+// line. Entries left without hostnames are dropped. It builds new entries,
+// as hostAliases may be shared with the alias cache, and returns the IPs
+// whose hostnames were dropped.
+func capHostnames(hostAliases []corev1.HostAlias, limit int) ([]corev1.HostAlias, []string) {
+	counts := make(map[string]int)
+	var capped []string
+	result := make([]corev1.HostAlias, 0, len(hostAliases))
+	for _, hostAlias := range hostAliases {
+		n := min(len(hostAlias.Hostnames), limit-counts[hostAlias.IP])
+		if n < len(hostAlias.Hostnames) && !slices.Contains(capped, hostAlias.IP) {
+			capped = append(capped, hostAlias.IP)
+		}
+		if n <= 0 {
+			continue
+		}
+		counts[hostAlias.IP] += n
+		result = append(result, corev1.HostAlias{IP: hostAlias.IP, Hostnames: slices.Clone(hostAlias.Hostnames[:n])})
+	}
+	return result, capped
+}
+
+// splitHostAliases returns one entry per hostname of each entry, keeping the
+// order. The merge combines entries by IP, so this runs on its result.
+func splitHostAliases(hostAliases []corev1.HostAlias) []corev1.HostAlias {
+	split := make([]corev1.HostAlias, 0, len(hostAliases))
+	for _, hostAlias := range hostAliases {
+		for _, hostname := range hostAlias.Hostnames {
+			split = append(split, corev1.HostAlias{
+				IP:        hostAlias.IP,
+				Hostnames: []string{hostname},
+			})
+		}
+	}
+	return split
+}
+
+func appendMissing(list []string, items ...string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// buildTestAliases builds host aliases for pod from the services served by
+// setupMutation.
+func buildTestAliases(t *testing.T, pod *corev1.Pod) hostAliasResult {
+	t.Helper()
+	result, err := buildHostAliases(context.Background(), serviceLister, podAliasScope(pod, pod.Namespace))
+	if err != nil {
+		t.Fatalf("building host aliases: %v", err)
+	}
+	return result
+}
+
+// builtServices builds host aliases for pod and returns the contributing
+// services as namespace/name.
+func builtServices(t *testing.T, pod *corev1.Pod) []string {
+	t.Helper()
+	result := buildTestAliases(t, pod)
+	services := make([]string, 0, len(result.Services))
+	for _, service := range result.Services {
+		services = append(services, service.String())
+	}
+	return services
+}
+
+// skipCount returns how many services have been left out for reason.
+func skipCount(reason string) float64 {
+	return testutil.ToFloat64(servicesSkippedTotal.WithLabelValues(reason))
+}
+
+func TestPodNamespacesAnnotationPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		want    []string
+	}{
+		{name: "no lists", want: []string{"a/svc", "c/svc"}},
+		{name: "allowlist", allowed: []string{"a", "b"}, want: []string{"a/svc"}},
+		{name: "denylist", denied: []string{"a"}, want: []string{"c/svc"}},
+		{name: "denylist wins", allowed: []string{"a", "c"}, denied: []string{"c"}, want: []string{"a/svc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t,
+				testService("a", "svc", "10.0.0.1"),
+				testService("b", "svc", "10.0.0.2"),
+				testService("c", "svc", "10.0.0.3"),
+			)
+			conf.AllowedServiceNamespaces = tt.allowed
+			conf.DeniedServiceNamespaces = tt.denied
+
+			pod := testPod("b")
+			pod.Annotations = map[string]string{conf.PodNamespacesAnnotation: "a,c"}
+			if got := builtServices(t, pod); !slices.Equal(got, tt.want) {
+				t.Fatalf("services = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkServiceListing compares listing services from the API server for
+// every pod with reading them from the informer's lister.
+func BenchmarkServiceListing(b *testing.B) {
+	services := make([]*corev1.Service, 0, 200)
+	objects := make([]runtime.Object, 0, 200)
+	for i := range 200 {
+		service := testService(fmt.Sprintf("ns-%d", i%10), fmt.Sprintf("svc-%d", i), fmt.Sprintf("10.0.%d.%d", i/250, i%250+1))
+		services = append(services, service)
+		objects = append(objects, service)
+	}
+	setupMutation(b, services...)
+	conf.ServiceScope = serviceScopeCluster
+	ctx := context.Background()
+	scope := podAliasScope(testPod("default"), "default")
+
+	b.Run("list per request", func(b *testing.B) {
+		cs := fake.NewSimpleClientset(objects...)
+		for range b.N {
+			lister, err := listServicesOnce(ctx, cs)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := buildHostAliases(ctx, lister, scope); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("lister", func(b *testing.B) {
+		for range b.N {
+			if _, err := buildHostAliases(ctx, serviceLister, scope); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestServiceNamespaceLists(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		want    []string
+	}{
+		{name: "empty lists", want: []string{"a/svc", "b/svc", "c/svc"}},
+		{name: "allowlist", allowed: []string{"a", "b"}, want: []string{"a/svc", "b/svc"}},
+		{name: "denylist", denied: []string{"b"}, want: []string{"a/svc", "c/svc"}},
+		{name: "on both lists", allowed: []string{"a", "b"}, denied: []string{"b"}, want: []string{"a/svc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t,
+				testService("a", "svc", "10.0.0.1"),
+				testService("b", "svc", "10.0.0.2"),
+				testService("c", "svc", "10.0.0.3"),
+			)
+			conf.ServiceScope = serviceScopeCluster
+			conf.AllowedServiceNamespaces = tt.allowed
+			conf.DeniedServiceNamespaces = tt.denied
+
+			for _, namespace := range []string{"a", "b", "c"} {
+				want := slices.Contains(tt.want, namespace+"/svc")
+				if got := serviceNamespaceAllowed(namespace); got != want {
+					t.Errorf("serviceNamespaceAllowed(%q) = %v, want %v", namespace, got, want)
+				}
+			}
+			if got := builtServices(t, testPod("a")); !slices.Equal(got, tt.want) {
+				t.Fatalf("services = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceClusterIPFamilies(t *testing.T) {
+	hostnames := []string{"svc.default.svc.cluster.local", "svc.default.svc", "svc.default"}
+	tests := []struct {
+		name       string
+		clusterIP  string
+		clusterIPs []string
+		want       []string
+	}{
+		{name: "IPv4 only", clusterIP: "10.0.0.1", clusterIPs: []string{"10.0.0.1"}, want: []string{"10.0.0.1"}},
+		{name: "IPv6 only", clusterIP: "fd00::1", clusterIPs: []string{"fd00::1"}, want: []string{"fd00::1"}},
+		{name: "dual-stack", clusterIP: "10.0.0.1", clusterIPs: []string{"10.0.0.1", "fd00::1"}, want: []string{"10.0.0.1", "fd00::1"}},
+		{name: "no ClusterIPs", clusterIP: "fd00::1", want: []string{"fd00::1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := testService("default", "svc", tt.clusterIP)
+			service.Spec.ClusterIPs = tt.clusterIPs
+			if got := serviceClusterIPs(service); !slices.Equal(got, tt.want) {
+				t.Fatalf("serviceClusterIPs = %q, want %q", got, tt.want)
+			}
+
+			setupMutation(t, service)
+			result := buildTestAliases(t, testPod("default"))
+			var want []corev1.HostAlias
+			for _, ip := range tt.want {
+				want = append(want, corev1.HostAlias{IP: ip, Hostnames: hostnames})
+			}
+			if !reflect.DeepEqual(result.HostAliases, want) {
+				t.Fatalf("host aliases = %+v, want %+v", result.HostAliases, want)
+			}
+		})
+	}
+}
+
+func TestServiceHostnamesClusterDomain(t *testing.T) {
+	tests := []struct {
+		clusterDomain string
+		want          string
+	}{
+		{"cluster.local", "svc.default.svc.cluster.local"},
+		{"corp.example", "svc.default.svc.corp.example"},
+		{".corp.example", "svc.default.svc.corp.example"},
+		{"corp.example.", "svc.default.svc.corp.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clusterDomain, func(t *testing.T) {
+			setupConfig(t, func(c *config) { c.ClusterDomain = tt.clusterDomain })
+			want := []string{tt.want, "svc.default.svc", "svc.default"}
+			if got := serviceHostnames(testService("default", "svc", "10.0.0.1")); !slices.Equal(got, want) {
+				t.Fatalf("hostnames = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestServiceHostnamesForms(t *testing.T) {
+	const (
+		fqdn  = "svc.default.svc.cluster.local"
+		svc   = "svc.default.svc"
+		short = "svc.default"
+	)
+	tests := []struct {
+		forms []string
+		want  []string
+	}{
+		{[]string{"fqdn"}, []string{fqdn}},
+		{[]string{"svc"}, []string{svc}},
+		{[]string{"short"}, []string{short}},
+		{[]string{"fqdn", "short"}, []string{fqdn, short}},
+		{[]string{"short", "svc", "fqdn"}, []string{short, svc, fqdn}},
+		{[]string{"FQDN", "fqdn", "svc"}, []string{fqdn, svc}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.forms, ","), func(t *testing.T) {
+			setupConfig(t, func(c *config) { c.HostnameForms = tt.forms })
+			if got := serviceHostnames(testService("default", "svc", "10.0.0.1")); !slices.Equal(got, tt.want) {
+				t.Fatalf("hostnames = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, forms := range [][]string{nil, {"fqdn", "cname"}} {
+			c := defaultConfig
+			c.HostnameForms = forms
+			if err := c.normalize(); err == nil {
+				t.Errorf("forms %q: expected an error", forms)
+			}
+		}
+	})
+}
+
+func TestServiceFormsAnnotation(t *testing.T) {
+	fqdnOnly := testService("default", "db", "10.0.0.1")
+	fqdnOnly.Annotations = map[string]string{defaultServiceFormsAnnotation: "FQDN"}
+	invalid := testService("default", "cache", "10.0.0.2")
+	invalid.Annotations = map[string]string{defaultServiceFormsAnnotation: "fqdn,cname"}
+	setupMutation(t, fqdnOnly, invalid, testService("default", "web", "10.0.0.3"))
+	conf.HostnameForms = []string{hostnameFormShort, hostnameFormSvc}
+
+	want := []corev1.HostAlias{
+		{IP: "10.0.0.1", Hostnames: []string{"db.default.svc.cluster.local"}},
+		// An invalid annotation keeps the global forms.
+		{IP: "10.0.0.2", Hostnames: []string{"cache.default", "cache.default.svc"}},
+		{IP: "10.0.0.3", Hostnames: []string{"web.default", "web.default.svc"}},
+	}
+	if got := buildTestAliases(t, testPod("default")).HostAliases; !reflect.DeepEqual(got, want) {
+		t.Fatalf("host aliases = %+v, want %+v", got, want)
+	}
+}
+
+func TestServiceSkipAnnotation(t *testing.T) {
+	skippedService := testService("default", "skipped", "10.0.0.1")
+	skippedService.Annotations = map[string]string{defaultServiceSkipAnnotation: "true"}
+	disabledSkip := testService("default", "kept", "10.0.0.2")
+	disabledSkip.Annotations = map[string]string{defaultServiceSkipAnnotation: "false"}
+	setupMutation(t, skippedService, disabledSkip, testService("default", "plain", "10.0.0.3"))
+
+	before := skipCount(skipReasonAnnotation)
+	want := []string{"default/kept", "default/plain"}
+	if got := builtServices(t, testPod("default")); !slices.Equal(got, want) {
+		t.Fatalf("services = %q, want %q", got, want)
+	}
+	if got := skipCount(skipReasonAnnotation) - before; got != 1 {
+		t.Fatalf("counted %v services skipped by annotation, want 1", got)
+	}
+}
+
+func TestMaxHostAliases(t *testing.T) {
+	setupMutation(t,
+		testService("a", "svc", "10.0.0.1"),
+		testService("b", "svc", "10.0.0.2"),
+		testService("c", "svc", "10.0.0.3"),
+		testService("c", "other", "10.0.0.4"),
+	)
+	conf.ServiceScope = serviceScopeCluster
+	conf.MaxHostAliases = 2
+
+	result := buildTestAliases(t, testPod("c"))
+	// The pod's own namespace comes first, whatever its name sorts as.
+	want := []string{"c/other", "c/svc"}
+	if got := builtServices(t, testPod("c")); !slices.Equal(got, want) {
+		t.Fatalf("services = %q, want %q", got, want)
+	}
+	if len(result.HostAliases) != 2 {
+		t.Fatalf("host aliases = %+v, want 2", result.HostAliases)
+	}
+	warning := "host-injector: host aliases truncated to 2 entries, 2 services omitted"
+	if !slices.Equal(result.Warnings, []string{warning}) {
+		t.Fatalf("warnings = %q, want %q", result.Warnings, warning)
+	}
+}
+
+func TestHostnameCollision(t *testing.T) {
+	setupMutation(t,
+		testService("default", "first", "10.0.0.1"),
+		testService("default", "second", "10.0.0.2"),
+	)
+	setupConfig(t, func(c *config) {
+		c.HostnameForms = []string{hostnameFormShort}
+		c.HostnameTemplate = "{{.Namespace}}.shared"
+	})
+
+	result := buildTestAliases(t, testPod("default"))
+	want := []corev1.HostAlias{
+		{IP: "10.0.0.1", Hostnames: []string{"first.default", "default.shared"}},
+		{IP: "10.0.0.2", Hostnames: []string{"second.default"}},
+	}
+	if !reflect.DeepEqual(result.HostAliases, want) {
+		t.Fatalf("host aliases = %+v, want %+v", result.HostAliases, want)
+	}
+	warning := `host-injector: hostname "default.shared" of service default/second already maps to service default/first, dropped`
+	if !slices.Equal(result.Warnings, []string{warning}) {
+		t.Fatalf("warnings = %q, want %q", result.Warnings, warning)
+	}
+}
+
+func TestExternalNameServicesSkipped(t *testing.T) {
+	external := testService("default", "external", "")
+	external.Spec.Type = corev1.ServiceTypeExternalName
+	external.Spec.ClusterIPs = nil
+	external.Spec.ExternalName = "db.example.com"
+	setupMutation(t, external, testService("default", "internal", "10.0.0.1"))
+
+	before := skipCount(skipReasonExternalName)
+	if got, want := builtServices(t, testPod("default")), []string{"default/internal"}; !slices.Equal(got, want) {
+		t.Fatalf("services = %q, want %q", got, want)
+	}
+	if got := skipCount(skipReasonExternalName) - before; got != 1 {
+		t.Fatalf("counted %v services skipped as %s, want 1", got, skipReasonExternalName)
+	}
+}
+
+func TestServiceTypes(t *testing.T) {
+	nodePort := testService("default", "nodeport", "10.0.0.2")
+	nodePort.Spec.Type = corev1.ServiceTypeNodePort
+	loadBalancer := testService("default", "loadbalancer", "10.0.0.3")
+	loadBalancer.Spec.Type = corev1.ServiceTypeLoadBalancer
+	tests := []struct {
+		types []string
+		want  []string
+	}{
+		{[]string{"ClusterIP"}, []string{"default/clusterip"}},
+		{[]string{"ClusterIP", "NodePort"}, []string{"default/clusterip", "default/nodeport"}},
+		{[]string{"ClusterIP", "NodePort", "LoadBalancer"}, []string{"default/clusterip", "default/loadbalancer", "default/nodeport"}},
+		{[]string{"LoadBalancer"}, []string{"default/loadbalancer"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.types, ","), func(t *testing.T) {
+			setupMutation(t, testService("default", "clusterip", "10.0.0.1"), nodePort, loadBalancer)
+			conf.ServiceTypes = tt.types
+
+			if got := builtServices(t, testPod("default")); !slices.Equal(got, tt.want) {
+				t.Fatalf("services = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireServicePorts(t *testing.T) {
+	portless := testService("default", "portless", "10.0.0.2")
+	portless.Spec.Ports = nil
+	tests := []struct {
+		require bool
+		want    []string
+		skipped float64
+	}{
+		{false, []string{"default/first", "default/portless"}, 0},
+		{true, []string{"default/first"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.FormatBool(tt.require), func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"), portless)
+			conf.RequireServicePorts = tt.require
+
+			before := skipCount(skipReasonNoPorts)
+			if got := builtServices(t, testPod("default")); !slices.Equal(got, tt.want) {
+				t.Fatalf("services = %q, want %q", got, tt.want)
+			}
+			if got := skipCount(skipReasonNoPorts) - before; got != tt.skipped {
+				t.Fatalf("counted %v services skipped as %s, want %v", got, skipReasonNoPorts, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestDeniedServices(t *testing.T) {
+	setupMutation(t,
+		testService("default", "kubernetes", "10.96.0.1"),
+		testService("default", "first", "10.0.0.1"),
+		testService("other", "kubernetes", "10.0.1.1"),
+	)
+	conf.ServiceScope = serviceScopeCluster
+	conf.DeniedServices = []string{"default/kubernetes"}
+
+	before := skipCount(skipReasonDenied)
+	// Only the named service is denied, not others with the same name.
+	want := []string{"default/first", "other/kubernetes"}
+	if got := builtServices(t, testPod("default")); !slices.Equal(got, want) {
+		t.Fatalf("services = %q, want %q", got, want)
+	}
+	if got := skipCount(skipReasonDenied) - before; got != 1 {
+		t.Fatalf("counted %v services skipped as %s, want 1", got, skipReasonDenied)
+	}
+}
+
+func TestExtraDomainSuffixes(t *testing.T) {
+	setupConfig(t, func(c *config) {
+		c.HostnameForms = []string{hostnameFormShort}
+		c.ExtraDomainSuffixes = []string{"corp.example", "internal"}
+	})
+	want := []string{"svc.default", "svc.default.corp.example", "svc.default.internal"}
+	if got := serviceHostnames(testService("default", "svc", "10.0.0.1")); !slices.Equal(got, want) {
+		t.Fatalf("hostnames = %q, want %q", got, want)
+	}
+}
+
+func TestOverlongHostnameDropped(t *testing.T) {
+	// Four 60-character labels make a suffix that is valid on its own but
+	// pushes the service's hostname past 253 characters.
+	suffix := strings.TrimSuffix(strings.Repeat(strings.Repeat("a", 60)+".", 4), ".")
+	setupMutation(t, testService("default", "svc", "10.0.0.1"))
+	setupConfig(t, func(c *config) {
+		c.HostnameForms = []string{hostnameFormShort}
+		c.ExtraDomainSuffixes = []string{suffix}
+	})
+
+	result := buildTestAliases(t, testPod("default"))
+	want := []corev1.HostAlias{{IP: "10.0.0.1", Hostnames: []string{"svc.default"}}}
+	if !reflect.DeepEqual(result.HostAliases, want) {
+		t.Fatalf("host aliases = %+v, want %+v", result.HostAliases, want)
+	}
+	warning := fmt.Sprintf("host-injector: hostname %q of service default/svc is not a valid RFC 1123 name, dropped", "svc.default."+suffix)
+	if !slices.Equal(result.Warnings, []string{warning}) {
+		t.Fatalf("warnings = %q, want %q", result.Warnings, warning)
+	}
+}
+
+func TestInvalidClusterIPSkipped(t *testing.T) {
+	malformed := testService("default", "malformed", "10.0.0.300")
+	setupMutation(t, malformed, testService("default", "valid", "10.0.0.1"))
+
+	before := skipCount(skipReasonInvalidIP)
+	if got, want := builtServices(t, testPod("default")), []string{"default/valid"}; !slices.Equal(got, want) {
+		t.Fatalf("services = %q, want %q", got, want)
+	}
+	if got := skipCount(skipReasonInvalidIP) - before; got != 1 {
+		t.Fatalf("counted %v services skipped as %s, want 1", got, skipReasonInvalidIP)
+	}
+}
+
+func TestHostnamesNormalized(t *testing.T) {
+	setupConfig(t, func(c *config) {
+		c.HostnameForms = []string{hostnameFormShort}
+		c.ExtraDomainSuffixes = []string{"Corp.Example.", ".internal"}
+		c.HostnameTemplate = "{{.Name}}.Mixed.Example."
+	})
+	want := []string{"svc.default", "svc.default.corp.example", "svc.default.internal", "svc.mixed.example"}
+	if got := serviceHostnames(testService("default", "svc", "10.0.0.1")); !slices.Equal(got, want) {
+		t.Fatalf("hostnames = %q, want %q", got, want)
+	}
+}
+
+func TestServiceHostnamesServiceDomain(t *testing.T) {
+	setupConfig(t, func(c *config) { c.ServiceDomain = "Services" })
+	want := []string{"svc.default.services.cluster.local", "svc.default.services", "svc.default"}
+	if got := serviceHostnames(testService("default", "svc", "10.0.0.1")); !slices.Equal(got, want) {
+		t.Fatalf("hostnames = %q, want %q", got, want)
+	}
+}
+
+func TestLocalServicesFirst(t *testing.T) {
+	services := []*corev1.Service{
+		testService("apps", "alpha", "10.0.0.1"),
+		testService("web", "zeta", "10.0.0.9"),
+		testService("db", "gamma", "10.0.0.2"),
+		testService("web", "beta", "10.0.0.5"),
+	}
+	tests := []struct {
+		localFirst bool
+		want       []string
+	}{
+		// Without the option aliases are ordered by IP alone.
+		{false, []string{"10.0.0.1", "10.0.0.2", "10.0.0.5", "10.0.0.9"}},
+		{true, []string{"10.0.0.5", "10.0.0.9", "10.0.0.1", "10.0.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("local services first %t", tt.localFirst), func(t *testing.T) {
+			setupMutation(t, services...)
+			conf.ServiceScope = serviceScopeCluster
+			conf.LocalServicesFirst = tt.localFirst
+
+			var ips []string
+			for _, hostAlias := range buildTestAliases(t, testPod("web")).HostAliases {
+				ips = append(ips, hostAlias.IP)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Fatalf("host alias IPs = %q, want %q", ips, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	_cli       kubernetes.Interface
+	_cliErr    error
+	initClient sync.Once
+)
+
+// restConfig builds the client configuration from the in-cluster environment,
+// falling back to a kubeconfig file, and applies the API server overrides.
+// The configured kube context, if any, selects the kubeconfig context.
+func restConfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		// The default rules read $KUBECONFIG as a list of files to merge,
+		// falling back to ~/.kube/config.
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			clientcmd.NewDefaultClientConfigLoadingRules(),
+			&clientcmd.ConfigOverrides{CurrentContext: conf.KubeContext},
+		).ClientConfig()
+	}
+	if err != nil && conf.APIServerHost != "" {
+		// The overrides alone are enough to reach the API server.
+		config, err = &rest.Config{}, nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("error building kubeconfig: %w", err)
+		return nil, err
+	}
+	applyAPIServerOverrides(config)
+	return config, nil
+}
+
+// applyAPIServerOverrides points config at the configured API server host, CA
+// bundle and token file, for environments where the service account files
+// are not mounted at their default paths.
+func applyAPIServerOverrides(config *rest.Config) {
+	if conf.APIServerHost != "" {
+		config.Host = conf.APIServerHost
+	}
+	if conf.APIServerCAFile != "" {
+		config.TLSClientConfig.CAFile = conf.APIServerCAFile
+		config.TLSClientConfig.CAData = nil
+	}
+	if conf.APIServerTokenFile != "" {
+		config.BearerTokenFile = conf.APIServerTokenFile
+		config.BearerToken = ""
+	}
+}
+
+func newClient() (kubernetes.Interface, error) {
+	config, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// client returns the process-wide Kubernetes client, building it on first use.
+func client() (kubernetes.Interface, error) {
+	initClient.Do(func() {
+		c, err := newClient()
+		if err != nil {
+			_cliErr = fmt.Errorf("error creating Kubernetes client: %w", err)
+			return
+		}
+		_cli = c
+	})
+
+	return _cli, _cliErr
+}
+
+// initialize builds the Kubernetes client and warms the service cache,
+// updating the readiness state as each step completes.
+func initialize(ctx context.Context) error {
+	cs, err := client()
+	if err != nil {
+		return err
+	}
+	serviceAccess.reviews = cs.AuthorizationV1().SubjectAccessReviews()
+	if conf.FailureEvents {
+		eventRecorder = newEventRecorder(ctx, cs)
+	}
+	readiness.clientReady.Store(true)
+
+	if conf.LeaderElection {
+		go func() {
+			if err := runLeaderElection(ctx, cs); err != nil {
+				slog.Error("leader election failed", "error", err)
+			}
+		}()
+	} else {
+		leading.Store(true)
+	}
+
+	lister, endpointSlices, err := startServiceInformer(ctx, cs)
+	if err != nil {
+		return fmt.Errorf("failed to start service informer: %w", err)
+	}
+	serviceLister, endpointSliceLister = lister, endpointSlices
+	readiness.cacheSynced.Store(true)
+	return nil
+}
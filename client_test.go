@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestConfigKubeconfig(t *testing.T) {
+	// Outside a cluster, restConfig falls back to the kubeconfig files.
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	// $KUBECONFIG is a list of files; the missing one is skipped.
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing")+string(filepath.ListSeparator)+filepath.Join("testdata", "kubeconfig"))
+	tests := []struct {
+		name    string
+		context string
+		host    string
+	}{
+		{"current context", "", "https://production.example.com:6443"},
+		{"kube context", "staging", "https://staging.example.com:6443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := conf
+			t.Cleanup(func() { conf = saved })
+			conf.KubeContext = tt.context
+
+			config, err := restConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Host != tt.host {
+				t.Fatalf("host = %q, want %q", config.Host, tt.host)
+			}
+			if config.BearerToken != "test-token" {
+				t.Fatalf("bearer token = %q, want the kubeconfig user's", config.BearerToken)
+			}
+		})
+	}
+}
+
+func TestRestConfigAPIServerOverrides(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("INJECTOR_API_SERVER", "https://api.example.com:6443")
+	t.Setenv("INJECTOR_API_SERVER_CA_FILE", "/etc/injector/ca.crt")
+	t.Setenv("INJECTOR_API_SERVER_TOKEN_FILE", "/etc/injector/token")
+	saved := conf
+	t.Cleanup(func() { conf = saved })
+	c, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatalf("loading the configuration: %v", err)
+	}
+	conf = c
+
+	tests := []struct {
+		name       string
+		kubeconfig string
+	}{
+		// The overrides replace what the kubeconfig says.
+		{"kubeconfig", filepath.Join("testdata", "kubeconfig")},
+		// They are enough on their own without one.
+		{"no kubeconfig", filepath.Join(t.TempDir(), "missing")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.kubeconfig)
+			config, err := restConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Host != "https://api.example.com:6443" {
+				t.Errorf("host = %q, want the override", config.Host)
+			}
+			if config.CAFile != "/etc/injector/ca.crt" || len(config.CAData) != 0 {
+				t.Errorf("CA file = %q with %d bytes of CA data, want only the override", config.CAFile, len(config.CAData))
+			}
+			if config.BearerTokenFile != "/etc/injector/token" || config.BearerToken != "" {
+				t.Errorf("token file = %q with token %q, want only the override", config.BearerTokenFile, config.BearerToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "time"
+
+// clock tells the time for the TTL caches, so tests can advance it instead of
+// sleeping.
+type clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// clockOrReal returns c, or the real clock when c is nil.
+func clockOrReal(c clock) clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
@@ -0,0 +1,799 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	defaultWatchLabelKey = "k8s-app"
+	defaultClusterDomain = "cluster.local"
+	defaultServiceDomain = "svc"
+
+	defaultPodDisableAnnotation       = "host-injector/disable"
+	defaultPodNamespacesAnnotation    = "host-injector/namespaces"
+	defaultServiceSkipAnnotation      = "host-injector/skip"
+	defaultInjectedFromAnnotation     = "host-injector/injected-from"
+	defaultAliasesHashAnnotation      = "host-injector/aliases-hash"
+	defaultPodStrategyAnnotation      = "host-injector/strategy"
+	defaultServiceEndpointsAnnotation = "host-injector/endpoints"
+	defaultPodOwnServicesAnnotation   = "host-injector/own-services"
+	defaultServiceFormsAnnotation     = "host-injector/forms"
+
+	defaultListenAddr    = ":9443"
+	defaultMutatePath    = "/mutate-core-v1-pod"
+	defaultTLSCertFile   = "testcerts/tls.crt"
+	defaultTLSKeyFile    = "testcerts/tls.key"
+	defaultTLSMinVersion = "1.2"
+	defaultMetricsAddr   = ":8080"
+
+	defaultAdmissionTimeout    = 2 * time.Second
+	defaultAliasCacheTTL       = 5 * time.Second
+	defaultListRetries         = 2
+	defaultListRetryDelay      = 100 * time.Millisecond
+	defaultAccessReviewTTL     = time.Minute
+	defaultShutdownGracePeriod = 10 * time.Second
+
+	defaultMaxPatchBytes   = 1 << 20
+	defaultMaxRequestBytes = 4 << 20
+
+	defaultLeaderElectionID = "host-injector"
+
+	defaultLogLevel  = "info"
+	defaultLogFormat = logFormatText
+)
+
+// Service scopes select which services contribute host aliases to a pod.
+const (
+	// serviceScopeNamespace uses only services in the pod's own namespace.
+	serviceScopeNamespace = "namespace"
+	// serviceScopeCluster uses services in all namespaces.
+	serviceScopeCluster = "cluster"
+)
+
+// Hostname forms generated for each service.
+const (
+	// hostnameFormFQDN is <name>.<namespace>.<service-domain>.<cluster-domain>,
+	// where the service domain is normally "svc".
+	hostnameFormFQDN = "fqdn"
+	// hostnameFormSvc is <name>.<namespace>.<service-domain>.
+	hostnameFormSvc = "svc"
+	// hostnameFormShort is <name>.<namespace>.
+	hostnameFormShort = "short"
+)
+
+// Injection modes select how service mappings are added to a pod.
+const (
+	// injectionModeHostAliases appends to Spec.HostAliases (/etc/hosts).
+	injectionModeHostAliases = "host-aliases"
+	// injectionModeDNSConfig adds the service namespaces to
+	// Spec.DNSConfig.Searches instead.
+	injectionModeDNSConfig = "dns-config"
+	// injectionModeBoth does both.
+	injectionModeBoth = "both"
+)
+
+// Failure modes decide what happens to a pod when host aliases cannot be
+// built.
+const (
+	// failureModeClosed rejects the admission with an error.
+	failureModeClosed = "fail-closed"
+	// failureModeOpen allows the pod unmutated, with a warning.
+	failureModeOpen = "fail-open"
+)
+
+// Injection strategies order injected host aliases relative to the pod's own.
+const (
+	// injectionStrategyAppend adds injected aliases after the pod's own, so
+	// hostnames the pod declares win.
+	injectionStrategyAppend = "append"
+	// injectionStrategyPrepend adds injected aliases before the pod's own, so
+	// injected hostnames win.
+	injectionStrategyPrepend = "prepend"
+	// injectionStrategyOverwrite replaces the pod's own aliases, so the pod
+	// only has injected ones.
+	injectionStrategyOverwrite = "overwrite"
+)
+
+// config holds the injector settings, resolved once at startup.
+type config struct {
+	// configFile is the YAML file the settings were read from, if any.
+	configFile string
+
+	// WatchLabelKey is the label a pod must carry to receive host aliases.
+	WatchLabelKey string
+	// WatchLabelValues optionally restricts matching to pods whose watch
+	// label has one of these values. Empty means any value matches.
+	WatchLabelValues []string
+	// PodDisableAnnotation exempts a pod from injection when set to "true",
+	// even if it carries the watch label.
+	PodDisableAnnotation string
+	// SkipPodSelector and SkipPodAnnotations exempt matching pods from
+	// injection even when they carry the watch label, e.g. pods already
+	// targeted by a sidecar injector. A pod is skipped if it matches the
+	// label selector or carries any of the annotations.
+	SkipPodSelector    string
+	skipPodSelector    labels.Selector
+	SkipPodAnnotations []string
+	// SkipPodNamespaces lists namespaces whose pods are never mutated,
+	// regardless of labels, as a complement to the webhook's own selectors.
+	SkipPodNamespaces []string
+	// PodNamespacesAnnotation lets a pod name the namespaces, comma-separated,
+	// whose services it receives aliases for, overriding ServiceScope. Only
+	// the named namespaces that AllowedServiceNamespaces and
+	// DeniedServiceNamespaces permit are used.
+	PodNamespacesAnnotation string
+	// PodStrategyAnnotation lets a pod choose the injection strategy,
+	// overriding InjectionStrategy.
+	PodStrategyAnnotation string
+	// ExcludeOwnServices leaves the pod's own services out of its aliases,
+	// so a pod does not reach itself through /etc/hosts. Its own services
+	// are those in its namespace whose selector matches its labels, and
+	// those named, comma-separated, in PodOwnServicesAnnotation.
+	ExcludeOwnServices       bool
+	PodOwnServicesAnnotation string
+	// InjectedFromAnnotation is set on mutated pods to the services whose
+	// IPs were injected, as comma-separated namespace/name pairs.
+	InjectedFromAnnotation string
+	// AliasesHash sets AliasesHashAnnotation on mutated pods to a checksum of
+	// the injected host aliases, so tooling can detect when they change.
+	AliasesHash           bool
+	AliasesHashAnnotation string
+
+	// ServiceScope is either serviceScopeNamespace or serviceScopeCluster.
+	ServiceScope string
+	// AllowedServiceNamespaces limits services to these namespaces; empty
+	// allows all. DeniedServiceNamespaces are always excluded and take
+	// precedence over the allowlist.
+	AllowedServiceNamespaces []string
+	DeniedServiceNamespaces  []string
+	// DeniedServices lists services, as namespace/name, that never
+	// contribute host aliases.
+	DeniedServices []string
+	// ServiceSelector is a label selector services must match to contribute
+	// host aliases. Empty selects every service.
+	ServiceSelector string
+	// ServiceTypes lists the service types that contribute host aliases.
+	ServiceTypes []string
+	// RequireServicePorts skips services that expose no ports, such as
+	// placeholders.
+	RequireServicePorts bool
+	// ExternalIPAliases also aliases each of a service's external IPs, in
+	// entries of their own after all others, so names still resolve to the
+	// cluster IP when there is one and to the external IP otherwise.
+	ExternalIPAliases bool
+	// RequireReadyEndpoints skips services without a ready endpoint, so pods
+	// are not given aliases for services nothing answers on.
+	RequireReadyEndpoints bool
+	// HeadlessEndpoints resolves headless services to their first ready
+	// endpoint address instead of skipping them.
+	HeadlessEndpoints bool
+	// EndpointAliases lets services annotated with ServiceEndpointsAnnotation
+	// set to "true" alias their ready endpoint addresses instead of their
+	// cluster IP. Both must be set.
+	EndpointAliases            bool
+	ServiceEndpointsAnnotation string
+	// ServiceSkipAnnotation marks services that never contribute host
+	// aliases when set to "true".
+	ServiceSkipAnnotation string
+
+	// ClusterDomain is the cluster DNS suffix used for fully qualified
+	// service hostnames.
+	ClusterDomain string
+	// ServiceDomain is the DNS label between the namespace and the cluster
+	// domain in service hostnames, "svc" in standard clusters.
+	ServiceDomain string
+	// HostnameForms selects which hostname forms are generated per service.
+	HostnameForms []string
+	// ServiceFormsAnnotation lets a service override HostnameForms for
+	// itself with a comma-separated list of forms.
+	ServiceFormsAnnotation string
+	// ExtraDomainSuffixes are extra DNS suffixes; each service also gets a
+	// <name>.<namespace>.<suffix> hostname per suffix.
+	ExtraDomainSuffixes []string
+	// HostnameTemplate is an optional text/template producing extra
+	// hostnames per service from .Name, .Namespace and .ClusterIP.
+	HostnameTemplate string
+	hostnameTemplate *template.Template
+
+	// MaxHostAliases caps the number of injected host alias entries; zero
+	// means no limit.
+	MaxHostAliases int
+	// MaxHostnamesPerAlias caps the injected hostnames of each IP, dropping
+	// the extras with a warning. The pod's own host aliases are left as they
+	// are. Zero means no limit.
+	MaxHostnamesPerAlias int
+	// LocalServicesFirst orders injected host aliases by service, those in
+	// the pod's namespace first, instead of by IP, so truncating the patch
+	// to MaxPatchBytes keeps the most relevant aliases.
+	LocalServicesFirst bool
+	// SplitHostAliases lays out the host aliases of mutated pods as one
+	// entry per hostname instead of one entry per IP, for tooling that
+	// expects a single hostname per entry. MaxHostAliases still counts IPs.
+	SplitHostAliases bool
+
+	// MaxPatchBytes caps the size of the JSON patch; host aliases are
+	// truncated to fit. Zero means no limit.
+	MaxPatchBytes int
+
+	// ListRetries is how many times a failed API list made by one-shot
+	// commands such as preview is retried, with exponential backoff from
+	// ListRetryDelay, within the admission timeout. The webhook reads from
+	// informer caches instead.
+	ListRetries    int
+	ListRetryDelay time.Duration
+
+	// MaxConcurrentBuilds caps how many admissions build host aliases at
+	// once; the others wait up to their admission timeout. Zero means no
+	// limit.
+	MaxConcurrentBuilds int
+
+	// AliasCacheTTL is how long assembled host aliases are reused; zero
+	// disables the cache.
+	AliasCacheTTL time.Duration
+
+	// ServiceAccessReview limits a pod's aliases to services its service
+	// account may get, checked with a SubjectAccessReview per service. The
+	// reviews of one admission run concurrently, a bounded number at a time,
+	// within AdmissionTimeout; with many services and a cold cache the first
+	// admissions may still time out, and are then handled per FailureMode.
+	ServiceAccessReview bool
+	// AccessReviewCacheTTL is how long access review decisions are reused.
+	AccessReviewCacheTTL time.Duration
+
+	// InjectionMode is one of the injectionMode constants.
+	InjectionMode string
+	// InjectionStrategy is the default injectionStrategy constant for pods
+	// without a strategy annotation.
+	InjectionStrategy string
+
+	// FailureMode is failureModeClosed or failureModeOpen.
+	FailureMode string
+
+	// FailureEvents records a Kubernetes event in the pod's namespace when a
+	// pod is rejected because its host aliases could not be built.
+	FailureEvents bool
+
+	// KubeContext selects the kubeconfig context when running outside the
+	// cluster; empty uses the current context.
+	KubeContext string
+
+	// APIServerHost, APIServerCAFile and APIServerTokenFile override how the
+	// Kubernetes client reaches the API server.
+	APIServerHost      string
+	APIServerCAFile    string
+	APIServerTokenFile string
+
+	// LeaderElection elects one replica, through a coordination.k8s.io
+	// Lease named LeaderElectionID, to own write-side work such as failure
+	// events. Every replica keeps serving admissions.
+	// LeaderElectionNamespace defaults to the namespace the injector runs in.
+	LeaderElection          bool
+	LeaderElectionID        string
+	LeaderElectionNamespace string
+
+	// ListenAddr is the TLS listen address for the webhook.
+	ListenAddr string
+	// MutatePath is the URL path the mutating webhook is served on.
+	MutatePath string
+	// TLSCertFile and TLSKeyFile are the webhook serving key pair.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSMinVersion is the minimum TLS version the webhook accepts, e.g.
+	// "1.2". TLSCipherSuites optionally restricts the cipher suites, by IANA
+	// name, used up to TLS 1.2; empty uses the Go defaults.
+	TLSMinVersion   string
+	tlsMinVersion   uint16
+	TLSCipherSuites []string
+	tlsCipherSuites []uint16
+	// TLSClientCAFile optionally enables mutual TLS: webhook clients must
+	// present a certificate signed by a CA in this bundle.
+	TLSClientCAFile string
+
+	// MetricsAddr is the plaintext listen address for the metrics, stats,
+	// version and health endpoints.
+	MetricsAddr string
+
+	// MaxRequestBytes caps the size of an admission request body.
+	MaxRequestBytes int64
+
+	// AdmissionTimeout bounds how long a single admission may take.
+	AdmissionTimeout time.Duration
+
+	// ShutdownGracePeriod bounds how long in-flight requests may take to
+	// complete after a termination signal.
+	ShutdownGracePeriod time.Duration
+
+	// LogLevel is one of debug, info, warn or error.
+	LogLevel string
+	// LogFormat is logFormatText or logFormatJSON.
+	LogFormat string
+}
+
+// defaultConfig holds the built-in defaults every load starts from, so a
+// reload does not keep settings since removed from the config file.
+var defaultConfig = config{
+	WatchLabelKey:              defaultWatchLabelKey,
+	PodDisableAnnotation:       defaultPodDisableAnnotation,
+	PodNamespacesAnnotation:    defaultPodNamespacesAnnotation,
+	PodStrategyAnnotation:      defaultPodStrategyAnnotation,
+	PodOwnServicesAnnotation:   defaultPodOwnServicesAnnotation,
+	InjectedFromAnnotation:     defaultInjectedFromAnnotation,
+	AliasesHashAnnotation:      defaultAliasesHashAnnotation,
+	ServiceScope:               serviceScopeNamespace,
+	ServiceTypes:               []string{string(corev1.ServiceTypeClusterIP)},
+	ServiceSkipAnnotation:      defaultServiceSkipAnnotation,
+	ServiceEndpointsAnnotation: defaultServiceEndpointsAnnotation,
+	ServiceFormsAnnotation:     defaultServiceFormsAnnotation,
+	ClusterDomain:              defaultClusterDomain,
+	ServiceDomain:              defaultServiceDomain,
+	HostnameForms:              []string{hostnameFormFQDN, hostnameFormSvc, hostnameFormShort},
+	MaxPatchBytes:              defaultMaxPatchBytes,
+	AliasCacheTTL:              defaultAliasCacheTTL,
+	ListRetries:                defaultListRetries,
+	ListRetryDelay:             defaultListRetryDelay,
+	AccessReviewCacheTTL:       defaultAccessReviewTTL,
+	InjectionMode:              injectionModeHostAliases,
+	InjectionStrategy:          injectionStrategyAppend,
+	FailureMode:                failureModeClosed,
+
+	LeaderElectionID: defaultLeaderElectionID,
+
+	ListenAddr:    defaultListenAddr,
+	MutatePath:    defaultMutatePath,
+	TLSCertFile:   defaultTLSCertFile,
+	TLSKeyFile:    defaultTLSKeyFile,
+	TLSMinVersion: defaultTLSMinVersion,
+	MetricsAddr:   defaultMetricsAddr,
+
+	MaxRequestBytes:     defaultMaxRequestBytes,
+	AdmissionTimeout:    defaultAdmissionTimeout,
+	ShutdownGracePeriod: defaultShutdownGracePeriod,
+
+	LogLevel:  defaultLogLevel,
+	LogFormat: defaultLogFormat,
+}
+
+var conf = defaultConfig
+
+// listFlag is a flag.Value holding a comma-separated list of strings.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = splitList(s)
+	return nil
+}
+
+// splitList splits a comma-separated string, trimming whitespace and dropping
+// empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envFlagSet wraps a flag.FlagSet so every flag can also be set through an
+// environment variable. Environment values go through the flag's own parser
+// and are overridden by flags given on the command line.
+type envFlagSet struct {
+	*flag.FlagSet
+	errs []error
+}
+
+func (fs *envFlagSet) fromEnv(name, env string) {
+	if v, ok := os.LookupEnv(env); ok {
+		if err := fs.Lookup(name).Value.Set(v); err != nil {
+			fs.errs = append(fs.errs, fmt.Errorf("invalid value %q for %s: %w", v, env, err))
+		}
+	}
+}
+
+func (fs *envFlagSet) stringVar(p *string, name, env, usage string) {
+	fs.StringVar(p, name, *p, usage+" (env "+env+")")
+	fs.fromEnv(name, env)
+}
+
+func (fs *envFlagSet) listVar(p *[]string, name, env, usage string) {
+	fs.Var((*listFlag)(p), name, usage+" (env "+env+")")
+	fs.fromEnv(name, env)
+}
+
+func (fs *envFlagSet) boolVar(p *bool, name, env, usage string) {
+	fs.BoolVar(p, name, *p, usage+" (env "+env+")")
+	fs.fromEnv(name, env)
+}
+
+func (fs *envFlagSet) intVar(p *int, name, env, usage string) {
+	fs.IntVar(p, name, *p, usage+" (env "+env+")")
+	fs.fromEnv(name, env)
+}
+
+func (fs *envFlagSet) int64Var(p *int64, name, env, usage string) {
+	fs.Int64Var(p, name, *p, usage+" (env "+env+")")
+	fs.fromEnv(name, env)
+}
+
+func (fs *envFlagSet) durationVar(p *time.Duration, name, env, usage string) {
+	fs.DurationVar(p, name, *p, usage+" (env "+env+")")
+	fs.fromEnv(name, env)
+}
+
+// loadConfig resolves the configuration from command-line flags, falling back
+// to environment variables, then to the config file and then to the built-in
+// defaults. Commands may register their own flags on flags before calling
+// loadConfig.
+func loadConfig(flags *flag.FlagSet, args []string) (config, error) {
+	c := defaultConfig
+
+	// The file provides the defaults the flags are registered with, so it is
+	// read first.
+	configPath := configFilePath(args)
+	if configPath != "" {
+		if err := loadConfigFile(&c, configPath); err != nil {
+			return c, err
+		}
+	}
+
+	fs := &envFlagSet{FlagSet: flags}
+	fs.stringVar(&configPath, "config", configFileEnv,
+		"YAML file with settings, overridden by environment variables and flags")
+	fs.stringVar(&c.WatchLabelKey, "watch-label", "INJECTOR_WATCH_LABEL",
+		"label key a pod must carry to receive host aliases")
+	fs.listVar(&c.WatchLabelValues, "watch-label-values", "INJECTOR_WATCH_LABEL_VALUES",
+		"comma-separated label values to match; empty matches any value")
+	fs.stringVar(&c.PodDisableAnnotation, "pod-disable-annotation", "INJECTOR_POD_DISABLE_ANNOTATION",
+		`pod annotation that disables injection when set to "true"`)
+	fs.stringVar(&c.SkipPodSelector, "skip-pod-selector", "INJECTOR_SKIP_POD_SELECTOR",
+		"label selector for pods that never receive aliases, even with the watch label")
+	fs.listVar(&c.SkipPodAnnotations, "skip-pod-annotations", "INJECTOR_SKIP_POD_ANNOTATIONS",
+		"comma-separated annotation keys; pods carrying any of them never receive aliases, e.g. sidecar.istio.io/status")
+	fs.listVar(&c.SkipPodNamespaces, "skip-pod-namespaces", "INJECTOR_SKIP_POD_NAMESPACES",
+		"comma-separated namespaces whose pods never receive aliases, e.g. kube-system")
+	fs.stringVar(&c.PodNamespacesAnnotation, "pod-namespaces-annotation", "INJECTOR_POD_NAMESPACES_ANNOTATION",
+		"pod annotation listing the namespaces whose services the pod receives aliases for")
+	fs.stringVar(&c.PodStrategyAnnotation, "pod-strategy-annotation", "INJECTOR_POD_STRATEGY_ANNOTATION",
+		"pod annotation overriding the injection strategy for a pod")
+	fs.boolVar(&c.ExcludeOwnServices, "exclude-own-services", "INJECTOR_EXCLUDE_OWN_SERVICES",
+		"leave out the services selecting the pod, or named in its own-services annotation")
+	fs.stringVar(&c.PodOwnServicesAnnotation, "pod-own-services-annotation", "INJECTOR_POD_OWN_SERVICES_ANNOTATION",
+		"pod annotation naming, comma-separated, services in its namespace that are its own")
+	fs.stringVar(&c.InjectedFromAnnotation, "injected-from-annotation", "INJECTOR_INJECTED_FROM_ANNOTATION",
+		"pod annotation recording the services whose IPs were injected")
+	fs.boolVar(&c.AliasesHash, "aliases-hash", "INJECTOR_ALIASES_HASH",
+		"annotate mutated pods with a checksum of the injected host aliases")
+	fs.stringVar(&c.AliasesHashAnnotation, "aliases-hash-annotation", "INJECTOR_ALIASES_HASH_ANNOTATION",
+		"pod annotation holding the injected host aliases checksum")
+	fs.stringVar(&c.ServiceScope, "service-scope", "INJECTOR_SERVICE_SCOPE",
+		`services to build aliases from: "namespace" for the pod's own namespace, "cluster" for all namespaces`)
+	fs.listVar(&c.AllowedServiceNamespaces, "allow-service-namespaces", "INJECTOR_ALLOW_SERVICE_NAMESPACES",
+		"comma-separated namespaces whose services may contribute aliases; empty allows all")
+	fs.listVar(&c.DeniedServiceNamespaces, "deny-service-namespaces", "INJECTOR_DENY_SERVICE_NAMESPACES",
+		"comma-separated namespaces whose services never contribute aliases; wins over the allowlist")
+	fs.listVar(&c.DeniedServices, "deny-services", "INJECTOR_DENY_SERVICES",
+		"comma-separated namespace/name services that never contribute aliases, e.g. default/kubernetes")
+	fs.stringVar(&c.ServiceSelector, "service-selector", "INJECTOR_SERVICE_SELECTOR",
+		"label selector services must match to contribute aliases, e.g. host-injector=enabled")
+	fs.listVar(&c.ServiceTypes, "service-types", "INJECTOR_SERVICE_TYPES",
+		"comma-separated service types that contribute aliases: ClusterIP, NodePort, LoadBalancer")
+	fs.boolVar(&c.RequireServicePorts, "require-service-ports", "INJECTOR_REQUIRE_SERVICE_PORTS",
+		"skip services that expose no ports")
+	fs.boolVar(&c.ExternalIPAliases, "external-ip-aliases", "INJECTOR_EXTERNAL_IP_ALIASES",
+		"also alias the external IPs of services, alongside their cluster IPs")
+	fs.boolVar(&c.RequireReadyEndpoints, "require-ready-endpoints", "INJECTOR_REQUIRE_READY_ENDPOINTS",
+		"skip services without a ready endpoint, using an EndpointSlice informer")
+	fs.boolVar(&c.HeadlessEndpoints, "headless-endpoints", "INJECTOR_HEADLESS_ENDPOINTS",
+		"alias headless services to their first ready endpoint address, using an EndpointSlice informer")
+	fs.boolVar(&c.EndpointAliases, "endpoint-aliases", "INJECTOR_ENDPOINT_ALIASES",
+		"let annotated services alias their ready endpoint addresses instead of their cluster IP")
+	fs.stringVar(&c.ServiceEndpointsAnnotation, "service-endpoints-annotation", "INJECTOR_SERVICE_ENDPOINTS_ANNOTATION",
+		`service annotation that switches the service to endpoint aliases when set to "true"`)
+	fs.stringVar(&c.ServiceSkipAnnotation, "service-skip-annotation", "INJECTOR_SERVICE_SKIP_ANNOTATION",
+		`service annotation that excludes the service when set to "true"`)
+	fs.stringVar(&c.ClusterDomain, "cluster-domain", "INJECTOR_CLUSTER_DOMAIN",
+		"cluster DNS domain used for fully qualified service hostnames")
+	fs.stringVar(&c.ServiceDomain, "service-domain", "INJECTOR_SERVICE_DOMAIN",
+		"DNS label between the namespace and the cluster domain in service hostnames")
+	fs.listVar(&c.HostnameForms, "hostname-forms", "INJECTOR_HOSTNAME_FORMS",
+		"comma-separated hostname forms to generate per service: fqdn, svc, short")
+	fs.stringVar(&c.ServiceFormsAnnotation, "service-forms-annotation", "INJECTOR_SERVICE_FORMS_ANNOTATION",
+		"service annotation overriding the hostname forms for that service, e.g. fqdn")
+	fs.listVar(&c.ExtraDomainSuffixes, "extra-domain-suffixes", "INJECTOR_EXTRA_DOMAIN_SUFFIXES",
+		"comma-separated extra DNS suffixes, each adding a <name>.<namespace>.<suffix> hostname per service")
+	fs.stringVar(&c.HostnameTemplate, "hostname-template", "INJECTOR_HOSTNAME_TEMPLATE",
+		"optional Go template for extra hostnames per service, e.g. {{.Name}}.internal.example.com")
+	fs.intVar(&c.MaxHostAliases, "max-host-aliases", "INJECTOR_MAX_HOST_ALIASES",
+		"maximum number of host alias entries to inject, preferring the pod's namespace; 0 means no limit")
+	fs.intVar(&c.MaxHostnamesPerAlias, "max-hostnames-per-alias", "INJECTOR_MAX_HOSTNAMES_PER_ALIAS",
+		"maximum number of injected hostnames per IP, dropping extras; 0 means no limit")
+	fs.boolVar(&c.LocalServicesFirst, "local-services-first", "INJECTOR_LOCAL_SERVICES_FIRST",
+		"order host aliases by service, the pod's namespace first, instead of by IP")
+	fs.boolVar(&c.SplitHostAliases, "split-host-aliases", "INJECTOR_SPLIT_HOST_ALIASES",
+		"emit one host alias entry per hostname instead of one per IP")
+	fs.intVar(&c.MaxPatchBytes, "max-patch-bytes", "INJECTOR_MAX_PATCH_BYTES",
+		"maximum size of the admission patch in bytes, truncating host aliases to fit; 0 means no limit")
+	fs.intVar(&c.ListRetries, "list-retries", "INJECTOR_LIST_RETRIES",
+		"how many times to retry a failed API list in one-shot commands such as preview")
+	fs.durationVar(&c.ListRetryDelay, "list-retry-delay", "INJECTOR_LIST_RETRY_DELAY",
+		"delay before the first API list retry, doubling for each further retry")
+	fs.intVar(&c.MaxConcurrentBuilds, "max-concurrent-builds", "INJECTOR_MAX_CONCURRENT_BUILDS",
+		"maximum number of admissions building host aliases at once; 0 means no limit")
+	fs.durationVar(&c.AliasCacheTTL, "alias-cache-ttl", "INJECTOR_ALIAS_CACHE_TTL",
+		"how long assembled host aliases are cached; 0 disables the cache")
+	fs.stringVar(&c.InjectionStrategy, "injection-strategy", "INJECTOR_INJECTION_STRATEGY",
+		"where injected aliases go: append after the pod's own, prepend before them, or overwrite them")
+	fs.boolVar(&c.ServiceAccessReview, "service-access-review", "INJECTOR_SERVICE_ACCESS_REVIEW",
+		"only inject services the pod's service account may get, checked with SubjectAccessReviews")
+	fs.durationVar(&c.AccessReviewCacheTTL, "access-review-cache-ttl", "INJECTOR_ACCESS_REVIEW_CACHE_TTL",
+		"how long access review decisions are cached")
+	fs.stringVar(&c.InjectionMode, "injection-mode", "INJECTOR_INJECTION_MODE",
+		"how to inject service mappings: host-aliases, dns-config or both")
+	fs.stringVar(&c.FailureMode, "failure-mode", "INJECTOR_FAILURE_MODE",
+		"what to do when host aliases cannot be built: fail-closed rejects the pod, fail-open admits it unmutated")
+	fs.boolVar(&c.FailureEvents, "failure-events", "INJECTOR_FAILURE_EVENTS",
+		"record an event in the pod's namespace when injection fails and the pod is rejected")
+	fs.stringVar(&c.KubeContext, "kube-context", "KUBECONTEXT",
+		"kubeconfig context to use outside the cluster; empty uses the current context")
+	fs.stringVar(&c.APIServerHost, "api-server", "INJECTOR_API_SERVER",
+		"override the Kubernetes API server URL")
+	fs.stringVar(&c.APIServerCAFile, "api-server-ca-file", "INJECTOR_API_SERVER_CA_FILE",
+		"override the CA bundle used to verify the Kubernetes API server")
+	fs.stringVar(&c.APIServerTokenFile, "api-server-token-file", "INJECTOR_API_SERVER_TOKEN_FILE",
+		"override the bearer token file used to authenticate to the Kubernetes API server")
+	fs.boolVar(&c.LeaderElection, "leader-elect", "INJECTOR_LEADER_ELECT",
+		"elect a leader replica to own write-side work such as failure events; all replicas serve admissions")
+	fs.stringVar(&c.LeaderElectionID, "leader-election-id", "INJECTOR_LEADER_ELECTION_ID",
+		"name of the Lease used for leader election")
+	fs.stringVar(&c.LeaderElectionNamespace, "leader-election-namespace", "INJECTOR_LEADER_ELECTION_NAMESPACE",
+		"namespace of the leader election Lease; defaults to the injector's namespace")
+	fs.stringVar(&c.ListenAddr, "listen-addr", "INJECTOR_LISTEN_ADDR",
+		"TLS listen address for the webhook")
+	fs.stringVar(&c.MutatePath, "mutate-path", "INJECTOR_MUTATE_PATH",
+		"URL path of the mutating webhook")
+	fs.stringVar(&c.TLSCertFile, "tls-cert-file", "INJECTOR_TLS_CERT_FILE",
+		"path to the webhook serving certificate")
+	fs.stringVar(&c.TLSKeyFile, "tls-key-file", "INJECTOR_TLS_KEY_FILE",
+		"path to the webhook serving private key")
+	fs.stringVar(&c.TLSMinVersion, "tls-min-version", "INJECTOR_TLS_MIN_VERSION",
+		"minimum TLS version for the webhook: 1.0, 1.1, 1.2 or 1.3")
+	fs.listVar(&c.TLSCipherSuites, "tls-cipher-suites", "INJECTOR_TLS_CIPHER_SUITES",
+		"comma-separated TLS 1.2 cipher suite names for the webhook; empty uses the Go defaults")
+	fs.stringVar(&c.TLSClientCAFile, "tls-client-ca-file", "INJECTOR_TLS_CLIENT_CA_FILE",
+		"CA bundle for verifying webhook client certificates; enables mutual TLS when set")
+	fs.stringVar(&c.MetricsAddr, "metrics-addr", "INJECTOR_METRICS_ADDR",
+		"plaintext listen address for the /metrics, /stats, /version, /healthz and /readyz endpoints")
+	fs.int64Var(&c.MaxRequestBytes, "max-request-bytes", "INJECTOR_MAX_REQUEST_BYTES",
+		"maximum size of an admission request body in bytes")
+	fs.durationVar(&c.AdmissionTimeout, "admission-timeout", "INJECTOR_ADMISSION_TIMEOUT",
+		"maximum time to spend on a single admission request")
+	fs.durationVar(&c.ShutdownGracePeriod, "shutdown-grace-period", "INJECTOR_SHUTDOWN_GRACE_PERIOD",
+		"how long to wait for in-flight requests on shutdown")
+	fs.stringVar(&c.LogLevel, "log-level", "LOG_LEVEL",
+		"log level: debug, info, warn or error")
+	fs.stringVar(&c.LogFormat, "log-format", "LOG_FORMAT",
+		"log format: text or json")
+	if err := errors.Join(fs.errs...); err != nil {
+		return c, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	if err := c.normalize(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// parseHostnameForms lowercases and deduplicates hostname forms, keeping
+// their order, and rejects unknown ones.
+func parseHostnameForms(list []string) ([]string, error) {
+	if len(list) == 0 {
+		return nil, errors.New("at least one hostname form is required")
+	}
+	forms := make([]string, 0, len(list))
+	for _, form := range list {
+		form = strings.ToLower(form)
+		switch form {
+		case hostnameFormFQDN, hostnameFormSvc, hostnameFormShort:
+		default:
+			return nil, fmt.Errorf("unknown hostname form %q", form)
+		}
+		if !slices.Contains(forms, form) {
+			forms = append(forms, form)
+		}
+	}
+	return forms, nil
+}
+
+func (c *config) normalize() error {
+	// A key that is not a valid label or annotation key can never match, so
+	// the webhook would silently do nothing.
+	for _, key := range []struct {
+		what string
+		p    *string
+	}{
+		{"watch label key", &c.WatchLabelKey},
+		{"pod disable annotation", &c.PodDisableAnnotation},
+		{"pod namespaces annotation", &c.PodNamespacesAnnotation},
+		{"pod strategy annotation", &c.PodStrategyAnnotation},
+		{"pod own services annotation", &c.PodOwnServicesAnnotation},
+		{"injected-from annotation", &c.InjectedFromAnnotation},
+		{"aliases hash annotation", &c.AliasesHashAnnotation},
+		{"service skip annotation", &c.ServiceSkipAnnotation},
+		{"service endpoints annotation", &c.ServiceEndpointsAnnotation},
+		{"service forms annotation", &c.ServiceFormsAnnotation},
+	} {
+		*key.p = strings.TrimSpace(*key.p)
+		if errs := validation.IsQualifiedName(*key.p); len(errs) > 0 {
+			return fmt.Errorf("invalid %s %q: %s", key.what, *key.p, strings.Join(errs, "; "))
+		}
+	}
+	switch c.ServiceScope {
+	case serviceScopeNamespace, serviceScopeCluster:
+	default:
+		return fmt.Errorf("unknown service scope %q", c.ServiceScope)
+	}
+	for _, service := range c.DeniedServices {
+		if namespace, name, ok := strings.Cut(service, "/"); !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid denied service %q, expected namespace/name", service)
+		}
+	}
+	for _, key := range c.SkipPodAnnotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid skip pod annotation %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
+	c.skipPodSelector = nil
+	if s := strings.TrimSpace(c.SkipPodSelector); s != "" {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid skip pod selector: %w", err)
+		}
+		c.skipPodSelector = selector
+	}
+	labelSelector, err := metav1.ParseToLabelSelector(strings.TrimSpace(c.ServiceSelector))
+	if err != nil {
+		return fmt.Errorf("invalid service selector: %w", err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return fmt.Errorf("invalid service selector: %w", err)
+	}
+	c.ServiceSelector = selector.String()
+	if len(c.ServiceTypes) == 0 {
+		return errors.New("at least one service type is required")
+	}
+	for _, t := range c.ServiceTypes {
+		switch corev1.ServiceType(t) {
+		case corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+		default:
+			return fmt.Errorf("unsupported service type %q", t)
+		}
+	}
+	c.ClusterDomain = strings.Trim(strings.TrimSpace(c.ClusterDomain), ".")
+	if c.ClusterDomain == "" {
+		return errors.New("cluster domain must not be empty")
+	}
+	c.ServiceDomain = strings.ToLower(strings.TrimSpace(c.ServiceDomain))
+	if errs := validation.IsDNS1123Label(c.ServiceDomain); len(errs) > 0 {
+		return fmt.Errorf("invalid service domain %q: %s", c.ServiceDomain, strings.Join(errs, "; "))
+	}
+	forms, err := parseHostnameForms(c.HostnameForms)
+	if err != nil {
+		return err
+	}
+	c.HostnameForms = forms
+	suffixes := make([]string, 0, len(c.ExtraDomainSuffixes))
+	for _, suffix := range c.ExtraDomainSuffixes {
+		if suffix = strings.Trim(suffix, "."); suffix == "" {
+			return errors.New("extra domain suffixes must not be empty")
+		}
+		suffixes = appendMissing(suffixes, suffix)
+	}
+	c.ExtraDomainSuffixes = suffixes
+	c.hostnameTemplate = nil
+	if strings.TrimSpace(c.HostnameTemplate) != "" {
+		tmpl, err := parseHostnameTemplate(c.HostnameTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid hostname template: %w", err)
+		}
+		c.hostnameTemplate = tmpl
+	}
+	if c.MaxHostAliases < 0 {
+		return errors.New("max host aliases must not be negative")
+	}
+	if c.MaxHostnamesPerAlias < 0 {
+		return errors.New("max hostnames per alias must not be negative")
+	}
+	if c.AccessReviewCacheTTL < 0 {
+		return errors.New("access review cache TTL must not be negative")
+	}
+	if c.ListRetries < 0 {
+		return errors.New("list retries must not be negative")
+	}
+	if c.ListRetryDelay < 0 {
+		return errors.New("list retry delay must not be negative")
+	}
+	if c.MaxConcurrentBuilds < 0 {
+		return errors.New("max concurrent builds must not be negative")
+	}
+	if c.MaxPatchBytes < 0 {
+		return errors.New("max patch bytes must not be negative")
+	}
+	switch c.InjectionMode {
+	case injectionModeHostAliases, injectionModeDNSConfig, injectionModeBoth:
+	default:
+		return fmt.Errorf("unknown injection mode %q", c.InjectionMode)
+	}
+	switch c.FailureMode {
+	case failureModeClosed, failureModeOpen:
+	default:
+		return fmt.Errorf("unknown failure mode %q", c.FailureMode)
+	}
+	c.LeaderElectionID = strings.TrimSpace(c.LeaderElectionID)
+	if c.LeaderElection && c.LeaderElectionID == "" {
+		return errors.New("leader election ID must not be empty")
+	}
+	if c.tlsMinVersion, err = parseTLSVersion(c.TLSMinVersion); err != nil {
+		return err
+	}
+	c.tlsCipherSuites = nil
+	if len(c.TLSCipherSuites) > 0 {
+		if c.tlsCipherSuites, err = parseCipherSuites(c.TLSCipherSuites); err != nil {
+			return err
+		}
+	}
+	c.MutatePath = strings.TrimSpace(c.MutatePath)
+	if !strings.HasPrefix(c.MutatePath, "/") {
+		return fmt.Errorf("mutate path %q must start with /", c.MutatePath)
+	}
+	if c.MutatePath == validatePath {
+		return fmt.Errorf("mutate path must differ from the validate path %s", validatePath)
+	}
+	if c.MaxRequestBytes <= 0 {
+		return errors.New("max request bytes must be positive")
+	}
+	switch c.InjectionStrategy {
+	case injectionStrategyAppend, injectionStrategyPrepend, injectionStrategyOverwrite:
+	default:
+		return fmt.Errorf("unknown injection strategy %q", c.InjectionStrategy)
+	}
+	if c.AdmissionTimeout <= 0 {
+		return errors.New("admission timeout must be positive")
+	}
+	if c.ShutdownGracePeriod < 0 {
+		return errors.New("shutdown grace period must not be negative")
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	c.LogFormat = strings.ToLower(strings.TrimSpace(c.LogFormat))
+	switch c.LogFormat {
+	case logFormatText, logFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	return nil
+}
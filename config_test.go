@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigRejectsInvalidKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"watch label", []string{"--watch-label", "bad key"}},
+		{"watch label prefix", []string{"--watch-label", "-bad.example/app"}},
+		{"disable annotation", []string{"--pod-disable-annotation", "host-injector/dis/able"}},
+		{"empty annotation", []string{"--pod-disable-annotation", " "}},
+		{"skip pod annotation", []string{"--skip-pod-annotations", "ok,not ok"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), tt.args); err == nil {
+				t.Fatalf("loadConfig(%q) accepted an invalid key", tt.args)
+			}
+		})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		args := []string{"--watch-label", "example.com/app", "--pod-disable-annotation", "example.com/disable"}
+		if _, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), args); err != nil {
+			t.Fatalf("loadConfig(%q): %v", args, err)
+		}
+	})
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	c, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", "testdata/config.yaml"})
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if c.WatchLabelKey != "example.com/app" {
+		t.Errorf("WatchLabelKey = %q, want example.com/app", c.WatchLabelKey)
+	}
+	if want := []string{"web", "worker"}; !slices.Equal(c.WatchLabelValues, want) {
+		t.Errorf("WatchLabelValues = %q, want %q", c.WatchLabelValues, want)
+	}
+	if want := []string{"kube-system"}; !slices.Equal(c.SkipPodNamespaces, want) {
+		t.Errorf("SkipPodNamespaces = %q, want %q", c.SkipPodNamespaces, want)
+	}
+	if c.ServiceScope != serviceScopeCluster {
+		t.Errorf("ServiceScope = %q, want %q", c.ServiceScope, serviceScopeCluster)
+	}
+	if c.MaxHostAliases != 50 {
+		t.Errorf("MaxHostAliases = %d, want 50", c.MaxHostAliases)
+	}
+	if c.AdmissionTimeout != 3*time.Second {
+		t.Errorf("AdmissionTimeout = %v, want 3s", c.AdmissionTimeout)
+	}
+	// Settings the file leaves out keep their defaults.
+	if c.PodDisableAnnotation != defaultConfig.PodDisableAnnotation {
+		t.Errorf("PodDisableAnnotation = %q, want the default %q", c.PodDisableAnnotation, defaultConfig.PodDisableAnnotation)
+	}
+
+	t.Run("flags override the file", func(t *testing.T) {
+		args := []string{"--config", "testdata/config.yaml", "--max-host-aliases", "10"}
+		c, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), args)
+		if err != nil {
+			t.Fatalf("loadConfig: %v", err)
+		}
+		if c.MaxHostAliases != 10 {
+			t.Errorf("MaxHostAliases = %d, want the flag's 10", c.MaxHostAliases)
+		}
+		if c.WatchLabelKey != "example.com/app" {
+			t.Errorf("WatchLabelKey = %q, want the file's example.com/app", c.WatchLabelKey)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("watchLabelKye: example.com/app\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path})
+		if err == nil || !strings.Contains(err.Error(), "watchLabelKye") {
+			t.Fatalf("loadConfig accepted unknown key, err = %v", err)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// configFileEnv names the environment variable holding the config file path,
+// an alternative to the --config flag.
+const configFileEnv = "INJECTOR_CONFIG"
+
+// configFilePath returns the config file named by a --config flag in args,
+// or by configFileEnv. The file is read before the other flags are registered,
+// so it has to be found without parsing them.
+func configFilePath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(configFileEnv)
+}
+
+// configFile is the YAML layout of the config file. Keys are the config field
+// names in camelCase, e.g. watchLabelKey. Durations are shadowed so they can be
+// written as "5s" rather than nanoseconds.
+type configFile struct {
+	*config
+
+	ListRetryDelay       *metav1.Duration
+	AliasCacheTTL        *metav1.Duration
+	AccessReviewCacheTTL *metav1.Duration
+	AdmissionTimeout     *metav1.Duration
+	ShutdownGracePeriod  *metav1.Duration
+}
+
+// loadConfigFile overlays the settings in the YAML file at path onto c.
+// Unknown keys are an error, so a typo does not silently keep a default.
+func loadConfigFile(c *config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	file := configFile{config: c}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	for _, d := range []struct {
+		from *metav1.Duration
+		to   *time.Duration
+	}{
+		{file.ListRetryDelay, &c.ListRetryDelay},
+		{file.AliasCacheTTL, &c.AliasCacheTTL},
+		{file.AccessReviewCacheTTL, &c.AccessReviewCacheTTL},
+		{file.AdmissionTimeout, &c.AdmissionTimeout},
+		{file.ShutdownGracePeriod, &c.ShutdownGracePeriod},
+	} {
+		if d.from != nil {
+			*d.to = d.from.Duration
+		}
+	}
+	c.configFile = path
+	return nil
+}
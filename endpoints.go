@@ -0,0 +1,111 @@
+package main
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+)
+
+// endpointSliceLister serves EndpointSlices from the informer cache. It is nil
+// unless an endpoint-based feature is enabled.
+var endpointSliceLister discoverylisters.EndpointSliceLister
+
+// endpointSlicesEnabled reports whether any feature needs EndpointSlices.
+func endpointSlicesEnabled() bool {
+	return conf.HeadlessEndpoints || conf.EndpointAliases || conf.RequireReadyEndpoints
+}
+
+// useEndpointAddresses reports whether the service's aliases point at its
+// ready endpoints instead of its cluster IP.
+func useEndpointAddresses(service *corev1.Service) bool {
+	return conf.EndpointAliases && annotationEnabled(service.GetAnnotations(), conf.ServiceEndpointsAnnotation)
+}
+
+// serviceEndpoint is a ready endpoint of a service.
+type serviceEndpoint struct {
+	Address string
+	// Hostname is the endpoint's own hostname, e.g. set for StatefulSet
+	// pods behind a headless service. It may be empty.
+	Hostname string
+}
+
+// isHeadless reports whether the service has no cluster IP by design.
+func isHeadless(service *corev1.Service) bool {
+	return service.Spec.ClusterIP == corev1.ClusterIPNone
+}
+
+// readyEndpoints returns the service's ready endpoints, one per address,
+// ordered by IP so the choice among them is stable.
+func readyEndpoints(service *corev1.Service) ([]serviceEndpoint, error) {
+	endpointSlices, err := endpointSliceLister.EndpointSlices(service.GetNamespace()).List(
+		labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: service.GetName()}))
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []serviceEndpoint
+	seen := make(map[string]bool)
+	for _, endpointSlice := range endpointSlices {
+		for _, endpoint := range endpointSlice.Endpoints {
+			// A nil Ready condition means unknown, which consumers treat as
+			// ready.
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			var hostname string
+			if endpoint.Hostname != nil {
+				hostname = *endpoint.Hostname
+			}
+			for _, address := range endpoint.Addresses {
+				if seen[address] {
+					continue
+				}
+				seen[address] = true
+				endpoints = append(endpoints, serviceEndpoint{Address: address, Hostname: hostname})
+			}
+		}
+	}
+	slices.SortFunc(endpoints, func(a, b serviceEndpoint) int {
+		return compareIPs(a.Address, b.Address)
+	})
+	return endpoints, nil
+}
+
+// headlessServiceIPs returns the first ready endpoint address of a headless
+// service, or none if no endpoint is ready.
+func headlessServiceIPs(service *corev1.Service) ([]string, error) {
+	endpoints, err := readyEndpoints(service)
+	if err != nil || len(endpoints) == 0 {
+		return nil, err
+	}
+	return []string{endpoints[0].Address}, nil
+}
+
+// endpointHostAliases returns one host alias per endpoint, mapping the
+// service hostnames to the endpoint address. An endpoint with its own
+// hostname also gets each service hostname prefixed with it, mirroring the
+// per-pod DNS records of headless services.
+func endpointHostAliases(endpoints []serviceEndpoint, hostnames []string) []corev1.HostAlias {
+	hostAliases := make([]corev1.HostAlias, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		names := make([]string, 0, 2*len(hostnames))
+		if endpoint.Hostname != "" {
+			for _, hostname := range hostnames {
+				name := endpoint.Hostname + "." + hostname
+				if len(validation.IsDNS1123Subdomain(name)) == 0 {
+					names = append(names, name)
+				}
+			}
+		}
+		names = append(names, hostnames...)
+		hostAliases = append(hostAliases, corev1.HostAlias{
+			IP:        endpoint.Address,
+			Hostnames: names,
+		})
+	}
+	return hostAliases
+}
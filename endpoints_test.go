@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+)
+
+// setupEndpointSlices serves endpointSlices to alias builds.
+func setupEndpointSlices(t *testing.T, endpointSlices ...*discoveryv1.EndpointSlice) {
+	t.Helper()
+	saved := endpointSliceLister
+	t.Cleanup(func() { endpointSliceLister = saved })
+	indexer := newNamespaceIndexer()
+	for _, endpointSlice := range endpointSlices {
+		if err := indexer.Add(endpointSlice); err != nil {
+			t.Fatalf("adding EndpointSlice: %v", err)
+		}
+	}
+	endpointSliceLister = discoverylisters.NewEndpointSliceLister(indexer)
+}
+
+// testEndpointSlice returns an EndpointSlice of the named service.
+func testEndpointSlice(namespace, service string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      service + "-abcde",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}
+}
+
+// testEndpoint returns an endpoint at address, with hostname unless it is
+// empty.
+func testEndpoint(address string, ready bool, hostname string) discoveryv1.Endpoint {
+	endpoint := discoveryv1.Endpoint{
+		Addresses:  []string{address},
+		Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+	}
+	if hostname != "" {
+		endpoint.Hostname = &hostname
+	}
+	return endpoint
+}
+
+// headlessService returns a headless service.
+func headlessService(namespace, name string) *corev1.Service {
+	service := testService(namespace, name, corev1.ClusterIPNone)
+	service.Spec.ClusterIPs = []string{corev1.ClusterIPNone}
+	return service
+}
+
+func TestHeadlessEndpoints(t *testing.T) {
+	hostnames := []string{"db.default.svc.cluster.local", "db.default.svc", "db.default"}
+	tests := []struct {
+		name      string
+		endpoints []discoveryv1.Endpoint
+		want      []corev1.HostAlias
+		skipped   float64
+	}{
+		{
+			name:      "ready endpoints",
+			endpoints: []discoveryv1.Endpoint{testEndpoint("10.1.0.9", true, ""), testEndpoint("10.1.0.2", false, ""), testEndpoint("10.1.0.5", true, "")},
+			want:      []corev1.HostAlias{{IP: "10.1.0.5", Hostnames: hostnames}},
+		},
+		{
+			name:      "no ready endpoints",
+			endpoints: []discoveryv1.Endpoint{testEndpoint("10.1.0.2", false, "")},
+			want:      []corev1.HostAlias{},
+			skipped:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, headlessService("default", "db"))
+			setupEndpointSlices(t, testEndpointSlice("default", "db", tt.endpoints...))
+			conf.HeadlessEndpoints = true
+
+			before := skipCount(skipReasonNoEndpoints)
+			result := buildTestAliases(t, testPod("default"))
+			if !reflect.DeepEqual(result.HostAliases, tt.want) {
+				t.Fatalf("host aliases = %+v, want %+v", result.HostAliases, tt.want)
+			}
+			if got := skipCount(skipReasonNoEndpoints) - before; got != tt.skipped {
+				t.Fatalf("counted %v services skipped as %s, want %v", got, skipReasonNoEndpoints, tt.skipped)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		setupMutation(t, headlessService("default", "db"))
+		if got := builtServices(t, testPod("default")); !slices.Equal(got, []string{}) {
+			t.Fatalf("services = %q, want none", got)
+		}
+	})
+}
+
+func TestEndpointAliases(t *testing.T) {
+	annotated := testService("default", "db", "10.0.0.1")
+	annotated.Annotations = map[string]string{defaultServiceEndpointsAnnotation: "true"}
+	setupMutation(t, annotated, testService("default", "web", "10.0.0.2"))
+	setupEndpointSlices(t,
+		testEndpointSlice("default", "db",
+			testEndpoint("10.1.0.2", true, ""),
+			testEndpoint("10.1.0.1", true, "db-0"),
+			testEndpoint("10.1.0.3", false, "db-2"),
+		),
+		testEndpointSlice("default", "web", testEndpoint("10.1.1.1", true, "")),
+	)
+	conf.EndpointAliases = true
+
+	db := []string{"db.default.svc.cluster.local", "db.default.svc", "db.default"}
+	want := []corev1.HostAlias{
+		// Aliases are sorted by IP, and the unready db-2 endpoint is left out.
+		{IP: "10.0.0.2", Hostnames: []string{"web.default.svc.cluster.local", "web.default.svc", "web.default"}},
+		{IP: "10.1.0.1", Hostnames: append([]string{"db-0.db.default.svc.cluster.local", "db-0.db.default.svc", "db-0.db.default"}, db...)},
+		{IP: "10.1.0.2", Hostnames: db},
+	}
+	if got := buildTestAliases(t, testPod("default")).HostAliases; !reflect.DeepEqual(got, want) {
+		t.Fatalf("host aliases = %+v, want %+v", got, want)
+	}
+}
+
+func TestRequireReadyEndpoints(t *testing.T) {
+	tests := []struct {
+		require bool
+		want    []string
+	}{
+		{false, []string{"default/cache", "default/db", "default/web"}},
+		// db has only an unready endpoint, and cache none at all.
+		{true, []string{"default/web"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("require ready endpoints %t", tt.require), func(t *testing.T) {
+			setupMutation(t,
+				testService("default", "web", "10.0.0.1"),
+				testService("default", "db", "10.0.0.2"),
+				testService("default", "cache", "10.0.0.3"),
+			)
+			setupEndpointSlices(t,
+				testEndpointSlice("default", "web", testEndpoint("10.1.0.1", true, "")),
+				testEndpointSlice("default", "db", testEndpoint("10.1.0.2", false, "")),
+			)
+			conf.RequireReadyEndpoints = tt.require
+
+			before := skipCount(skipReasonNoEndpoints)
+			got := builtServices(t, testPod("default"))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("services = %q, want %q", got, tt.want)
+			}
+			if got, want := skipCount(skipReasonNoEndpoints)-before, float64(3-len(tt.want)); got != want {
+				t.Fatalf("counted %v services skipped as %s, want %v", got, skipReasonNoEndpoints, want)
+			}
+		})
+	}
+}
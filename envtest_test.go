@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// TestEnvtestAdmission runs pods through the webhook as registered with a
+// real API server, covering the patch format and review wiring end to end.
+// It needs the control plane binaries named by KUBEBUILDER_ASSETS, as set up
+// by setup-envtest, and is skipped without them.
+func TestEnvtestAdmission(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	savedConf, savedLister := conf, serviceLister
+	t.Cleanup(func() {
+		conf, serviceLister = savedConf, savedLister
+		readiness.clientReady.Store(false)
+		readiness.cacheSynced.Store(false)
+		hostAliasCache.invalidate()
+	})
+
+	env := &envtest.Environment{
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			MutatingWebhooks: []*admissionregistrationv1.MutatingWebhookConfiguration{
+				webhookConfiguration("host-injector", "pods.host-injector.io", "default", "host-injector", 443, nil),
+			},
+		},
+	}
+	config, err := env.Start()
+	if err != nil {
+		t.Fatalf("starting the control plane: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("stopping the control plane: %v", err)
+		}
+	})
+
+	// envtest points the webhook at its local serving address, with a
+	// certificate signed by the CA it registered.
+	opts := env.WebhookInstallOptions
+	cert, err := tls.LoadX509KeyPair(filepath.Join(opts.LocalServingCertDir, "tls.crt"), filepath.Join(opts.LocalServingCertDir, "tls.key"))
+	if err != nil {
+		t.Fatalf("loading the serving certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", net.JoinHostPort(opts.LocalServingHost, strconv.Itoa(opts.LocalServingPort)),
+		&tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("listening for admission reviews: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(handleMutatePod)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("creating the client: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// The API server allocates the cluster IP.
+	service := testService("default", "first", "")
+	service.Spec.ClusterIP, service.Spec.ClusterIPs = "", nil
+	service, err = cs.CoreV1().Services("default").Create(ctx, service, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("creating the service: %v", err)
+	}
+	lister, _, err := startServiceInformer(ctx, cs)
+	if err != nil {
+		t.Fatalf("starting the service informer: %v", err)
+	}
+	serviceLister = lister
+	readiness.clientReady.Store(true)
+	readiness.cacheSynced.Store(true)
+
+	t.Run("watched pod", func(t *testing.T) {
+		pod, err := cs.CoreV1().Pods("default").Create(ctx, testPod("default"), metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("creating the pod: %v", err)
+		}
+		want := corev1.HostAlias{
+			IP:        service.Spec.ClusterIP,
+			Hostnames: []string{"first.default.svc.cluster.local", "first.default.svc", "first.default"},
+		}
+		if !slices.ContainsFunc(pod.Spec.HostAliases, func(hostAlias corev1.HostAlias) bool {
+			return hostAlias.IP == want.IP && slices.Equal(hostAlias.Hostnames, want.Hostnames)
+		}) {
+			t.Fatalf("host aliases = %+v, want them to include %+v", pod.Spec.HostAliases, want)
+		}
+	})
+
+	t.Run("disabled pod", func(t *testing.T) {
+		// The pod carries the watch label, so the API server sends it to the
+		// webhook, which skips it for its disable annotation.
+		pod := testPod("default")
+		pod.Name = "disabled"
+		pod.Annotations = map[string]string{conf.PodDisableAnnotation: "true"}
+		before := testutil.ToFloat64(admissionDecisionsTotal.WithLabelValues(decisionSkippedDisabled))
+		created, err := cs.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("creating the pod: %v", err)
+		}
+		if after := testutil.ToFloat64(admissionDecisionsTotal.WithLabelValues(decisionSkippedDisabled)); after != before+1 {
+			t.Fatalf("webhook recorded %v skipped-disabled decisions, want %v", after, before+1)
+		}
+		if len(created.Spec.HostAliases) != 0 {
+			t.Fatalf("host aliases = %+v, want none", created.Spec.HostAliases)
+		}
+		if _, ok := created.Annotations[conf.InjectedFromAnnotation]; ok {
+			t.Fatalf("disabled pod has the %s annotation", conf.InjectedFromAnnotation)
+		}
+	})
+}
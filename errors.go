@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons reported in the status of errored admission responses, so clients
+// can tell failure modes apart without parsing messages.
+const (
+	reasonNoRequest     metav1.StatusReason = "NoAdmissionRequest"
+	reasonDecodeFailed  metav1.StatusReason = "PodDecodeFailed"
+	reasonNotReady      metav1.StatusReason = "NotReady"
+	reasonListFailed    metav1.StatusReason = "ServiceListFailed"
+	reasonMarshalFailed metav1.StatusReason = "PodMarshalFailed"
+	reasonPatchFailed   metav1.StatusReason = "PatchFailed"
+	reasonPanic         metav1.StatusReason = "InternalPanic"
+)
+
+// admissionError is a failure to admit a pod, carrying the HTTP status code
+// and reason reported in the response.
+type admissionError struct {
+	code   int32
+	reason metav1.StatusReason
+	err    error
+}
+
+func (e *admissionError) Error() string { return e.err.Error() }
+
+func (e *admissionError) Unwrap() error { return e.err }
+
+var errNoRequest = &admissionError{http.StatusBadRequest, reasonNoRequest, errors.New("admission review has no request")}
+
+func decodeError(err error) error {
+	return &admissionError{http.StatusBadRequest, reasonDecodeFailed, err}
+}
+
+func notReadyError(err error) error {
+	return &admissionError{http.StatusServiceUnavailable, reasonNotReady, err}
+}
+
+// listError wraps a failure to build host aliases. Running out of time is
+// reported as a gateway timeout.
+func listError(err error) error {
+	code := int32(http.StatusInternalServerError)
+	if errors.Is(err, context.DeadlineExceeded) {
+		code = http.StatusGatewayTimeout
+	}
+	return &admissionError{code, reasonListFailed, err}
+}
+
+func marshalError(err error) error {
+	return &admissionError{http.StatusInternalServerError, reasonMarshalFailed, err}
+}
+
+func patchError(err error) error {
+	return &admissionError{http.StatusInternalServerError, reasonPatchFailed, err}
+}
+
+// panicError wraps a value recovered from a panic.
+func panicError(p any) error {
+	return &admissionError{http.StatusInternalServerError, reasonPanic, fmt.Errorf("panic: %v", p)}
+}
+
+// errorStatus returns the status code and reason for err. Errors that are not
+// admissionErrors are internal errors.
+func errorStatus(err error) (int32, metav1.StatusReason) {
+	var aerr *admissionError
+	if errors.As(err, &aerr) {
+		return aerr.code, aerr.reason
+	}
+	return http.StatusInternalServerError, metav1.StatusReasonInternalError
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const eventReasonInjectionFailed = "HostAliasInjectionFailed"
+
+// eventRecorder records events about admitted pods. It is nil until the
+// client is ready, or when failure events are disabled.
+var eventRecorder record.EventRecorder
+
+// newEventRecorder returns a recorder that writes events through cs until ctx
+// is done. Events are sent asynchronously, so recording never blocks an
+// admission.
+func newEventRecorder(ctx context.Context, cs kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cs.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "host-injector"})
+}
+
+// recordInjectionFailure records a warning event about a pod that was
+// rejected because its host aliases could not be built. The pod does not
+// exist yet, so the event is attached to its name in namespace. Only the
+// leader records events, so an outage seen by every replica is not reported
+// once per replica.
+func recordInjectionFailure(namespace, name string, err error) {
+	if eventRecorder == nil || !leading.Load() {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       name,
+	}
+	eventRecorder.Eventf(ref, corev1.EventTypeWarning, eventReasonInjectionFailed,
+		"Failed to inject host aliases: %v", err)
+}
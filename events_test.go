@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMutatePodsRecordsFailureEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		events int
+	}{
+		{"list error", false, 1},
+		{"dry-run list error", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t)
+			recorder := setupEvents(t)
+			serviceLister = failingServiceLister{errors.New("connection refused")}
+
+			review := podReview(t, testPod("default"), "uid")
+			review.Request.DryRun = &tt.dryRun
+			if resp := mutatePods(context.Background(), review); resp.Allowed {
+				t.Fatalf("expected a rejection, got %+v", resp)
+			}
+			if len(recorder.Events) != tt.events {
+				t.Fatalf("recorded %d events, want %d", len(recorder.Events), tt.events)
+			}
+			if tt.events == 0 {
+				return
+			}
+			event := <-recorder.Events
+			if !strings.HasPrefix(event, "Warning "+eventReasonInjectionFailed+" ") || !strings.Contains(event, "connection refused") {
+				t.Fatalf("event = %q, want a %s warning naming the error", event, eventReasonInjectionFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// readinessState tracks whether the injector can serve admissions. The webhook
+// and the /readyz endpoint share it so traffic is only accepted once the
+// Kubernetes client is built and the service cache has synced.
+type readinessState struct {
+	clientReady atomic.Bool
+	cacheSynced atomic.Bool
+}
+
+var readiness readinessState
+
+// err returns why the injector is not ready, or nil when it is.
+func (s *readinessState) err() error {
+	if !s.clientReady.Load() {
+		return errors.New("kubernetes client is not ready")
+	}
+	if !s.cacheSynced.Load() {
+		return errors.New("service cache has not synced")
+	}
+	return nil
+}
+
+// handleHealthz reports that the process is up. It needs no request body, so
+// it can back a kubelet liveness probe directly.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// handleReadyz returns 503 until the injector is ready to serve admissions.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := readiness.err(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
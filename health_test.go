@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// get fetches path from server, returning the status code and body.
+func get(t *testing.T, server *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsMux(t *testing.T) {
+	setupMutation(t)
+	server := httptest.NewServer(newMetricsMux())
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/healthz", "ok"},
+		{"/readyz", "ok"},
+		{"/metrics", "host_injector_mutate_duration_seconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			code, body := get(t, server, tt.path)
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", code, http.StatusOK, body)
+			}
+			if !strings.Contains(body, tt.want) {
+				t.Fatalf("body %q does not contain %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadyzWaitsForCacheSync(t *testing.T) {
+	savedLister, savedSlices, savedReviews := serviceLister, endpointSliceLister, serviceAccess.reviews
+	t.Cleanup(func() {
+		serviceLister, endpointSliceLister, serviceAccess.reviews = savedLister, savedSlices, savedReviews
+		_cli, _cliErr, initClient = nil, nil, sync.Once{}
+		readiness.clientReady.Store(false)
+		readiness.cacheSynced.Store(false)
+		leading.Store(false)
+	})
+	// Listing services blocks until released, holding the informer short of
+	// its initial sync.
+	release := make(chan struct{})
+	cs := fake.NewSimpleClientset(testService("default", "first", "10.0.0.1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cs.PrependReactor("list", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return false, nil, nil
+	})
+	initClient.Do(func() { _cli = cs })
+
+	server := httptest.NewServer(newMetricsMux())
+	t.Cleanup(server.Close)
+	var initErr error
+	done := make(chan struct{})
+	go func() {
+		initErr = initialize(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitFor(t, "the client to be ready", readiness.clientReady.Load)
+	if code, body := get(t, server, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("status before sync = %d, want %d: %s", code, http.StatusServiceUnavailable, body)
+	}
+
+	close(release)
+	<-done
+	if initErr != nil {
+		t.Fatalf("initialize: %v", initErr)
+	}
+	if code, body := get(t, server, "/readyz"); code != http.StatusOK {
+		t.Fatalf("status after sync = %d, want %d: %s", code, http.StatusOK, body)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// serviceLister serves services from the shared informer cache so admissions
+// do not hit the API server.
+var serviceLister corelisters.ServiceLister
+
+// startServiceInformer starts a shared service informer, and an EndpointSlice
+// informer when a feature needs one, and blocks until their caches have
+// synced or ctx is done. The EndpointSlice lister is nil when not needed.
+func startServiceInformer(ctx context.Context, cs kubernetes.Interface) (corelisters.ServiceLister, discoverylisters.EndpointSliceLister, error) {
+	// Only services matching the configured selector are cached, so the
+	// lister never sees the others. The selector is read once, as the
+	// informer goroutines outlive this call.
+	selector := conf.ServiceSelector
+	factory := informers.NewSharedInformerFactoryWithOptions(cs, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}),
+	)
+	informer := factory.Core().V1().Services()
+	lister := informer.Lister()
+	if _, err := informer.Informer().AddEventHandler(invalidateAliasCache); err != nil {
+		return nil, nil, fmt.Errorf("failed to register service event handler: %w", err)
+	}
+
+	var endpointSlices discoverylisters.EndpointSliceLister
+	if endpointSlicesEnabled() {
+		// EndpointSlices are not filtered by the service selector, so they use
+		// their own factory.
+		endpointsFactory := informers.NewSharedInformerFactory(cs, 0)
+		endpointsInformer := endpointsFactory.Discovery().V1().EndpointSlices()
+		endpointSlices = endpointsInformer.Lister()
+		if _, err := endpointsInformer.Informer().AddEventHandler(invalidateAliasCache); err != nil {
+			return nil, nil, fmt.Errorf("failed to register EndpointSlice event handler: %w", err)
+		}
+		endpointsFactory.Start(ctx.Done())
+		if err := waitForCacheSync(ctx, endpointsFactory); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	factory.Start(ctx.Done())
+	if err := waitForCacheSync(ctx, factory); err != nil {
+		return nil, nil, err
+	}
+	return lister, endpointSlices, nil
+}
+
+// invalidateAliasCache drops cached host aliases on any change to the
+// objects they are built from.
+var invalidateAliasCache = cache.ResourceEventHandlerFuncs{
+	AddFunc:    func(interface{}) { hostAliasCache.invalidate() },
+	UpdateFunc: func(interface{}, interface{}) { hostAliasCache.invalidate() },
+	DeleteFunc: func(interface{}) { hostAliasCache.invalidate() },
+}
+
+func waitForCacheSync(ctx context.Context, factory informers.SharedInformerFactory) error {
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync informer cache for %v", informerType)
+		}
+	}
+	return nil
+}
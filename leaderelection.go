@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaseTimings are the Lease duration, renew deadline and retry period of the
+// election; tests shorten them.
+var leaseTimings = struct {
+	duration, renewDeadline, retryPeriod time.Duration
+}{15 * time.Second, 10 * time.Second, 2 * time.Second}
+
+// leading reports whether this replica owns write-side work. Every replica
+// serves admissions from its own cache; only the leader may change cluster
+// state. Without leader election every replica leads.
+var leading atomic.Bool
+
+// leaderElectionNamespace returns the namespace holding the lease: the
+// configured one, else the namespace the injector runs in.
+func leaderElectionNamespace() string {
+	if conf.LeaderElectionNamespace != "" {
+		return conf.LeaderElectionNamespace
+	}
+	if ns, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(ns)); ns != "" {
+			return ns
+		}
+	}
+	return metav1.NamespaceDefault
+}
+
+// runLeaderElection competes for the leader lease until ctx is done, updating
+// leading as leadership is acquired and lost.
+func runLeaderElection(ctx context.Context, cs kubernetes.Interface) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      conf.LeaderElectionID,
+			Namespace: leaderElectionNamespace(),
+		},
+		Client:     cs.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	logger := slog.With("lease", lock.LeaseMeta.Namespace+"/"+lock.LeaseMeta.Name, "identity", identity)
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseTimings.duration,
+		RenewDeadline:   leaseTimings.renewDeadline,
+		RetryPeriod:     leaseTimings.retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				logger.Info("started leading")
+				leading.Store(true)
+			},
+			OnStoppedLeading: func() {
+				logger.Info("stopped leading")
+				leading.Store(false)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					logger.Info("new leader elected", "leader", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Run returns when leadership is lost; keep competing until shutdown.
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
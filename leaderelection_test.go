@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	savedConf, savedTimings := conf, leaseTimings
+	t.Cleanup(func() {
+		conf, leaseTimings = savedConf, savedTimings
+		leading.Store(false)
+	})
+	conf.LeaderElectionNamespace = "default"
+	leaseTimings.duration, leaseTimings.renewDeadline, leaseTimings.retryPeriod = 2*time.Second, time.Second, 50*time.Millisecond
+
+	// Lease updates fail once unreachable is set, e.g. while the API
+	// server is down.
+	var unreachable atomic.Bool
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if unreachable.Load() {
+			return true, nil, errors.New("connection refused")
+		}
+		return false, nil, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runLeaderElection(ctx, cs) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitFor(t, "leadership to be acquired", leading.Load)
+
+	// The lease can no longer be renewed, so leadership is lost after the
+	// renew deadline.
+	unreachable.Store(true)
+	waitFor(t, "leadership to be lost", func() bool { return !leading.Load() })
+}
+
+func TestRecordInjectionFailureRequiresLeadership(t *testing.T) {
+	savedRecorder := eventRecorder
+	t.Cleanup(func() {
+		eventRecorder = savedRecorder
+		leading.Store(false)
+	})
+	recorder := record.NewFakeRecorder(1)
+	eventRecorder = recorder
+
+	leading.Store(false)
+	recordInjectionFailure("default", "app", errors.New("boom"))
+	if len(recorder.Events) != 0 {
+		t.Fatalf("follower recorded event %q", <-recorder.Events)
+	}
+
+	leading.Store(true)
+	recordInjectionFailure("default", "app", errors.New("boom"))
+	if len(recorder.Events) != 1 {
+		t.Fatal("leader recorded no event")
+	}
+}
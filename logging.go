@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+// Log formats accepted by the log-format option.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+func parseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return l, fmt.Errorf("invalid log level %q", level)
+	}
+	return l, nil
+}
+
+// newLogger builds a logger writing to w at the given level and format.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// setupLogging installs logger as the default slog logger and routes klog
+// output from client-go through it, so there is a single log stream.
+func setupLogging(logger *slog.Logger) {
+	slog.SetDefault(logger)
+	klog.SetLogger(logr.FromSlogHandler(logger.Handler()))
+}
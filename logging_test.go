@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	for _, format := range []string{logFormatText, logFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf, "info", format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger.Debug("debug line")
+			logger.Info("info line")
+
+			out := buf.String()
+			if strings.Contains(out, "debug line") {
+				t.Fatalf("info logger wrote a debug line: %q", out)
+			}
+			if !strings.Contains(out, "info line") {
+				t.Fatalf("info logger dropped an info line: %q", out)
+			}
+			if format == logFormatJSON {
+				var line map[string]any
+				if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+					t.Fatalf("decoding JSON log line %q: %v", out, err)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := newLogger(&bytes.Buffer{}, "verbose", logFormatText); err == nil {
+			t.Error("expected an error for an unknown level")
+		}
+		if _, err := newLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+			t.Error("expected an error for an unknown format")
+		}
+	})
+}
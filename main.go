@@ -2,229 +2,697 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"gomodules.xyz/jsonpatch/v2"
 	v1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/klog/v2"
 )
 
-const (
-	watchingLabelKey = "k8s-app"
-)
-
-func isWatching(pod *corev1.Pod) bool {
-	if _, ok := pod.Labels[watchingLabelKey]; !ok {
-		return false
-	} else {
-		return true
+// isWatching reports whether the pod carries the watch label and, when a value
+// filter is configured, whether the label value is one of the allowed values.
+// The label value is returned for logging.
+func isWatching(pod *corev1.Pod) (string, bool) {
+	value, ok := pod.Labels[conf.WatchLabelKey]
+	if !ok {
+		return "", false
 	}
-}
-
-var (
-	_cli       *kubernetes.Clientset
-	initClient sync.Once
-)
-
-func newClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		var configPath string
-		if p := os.Getenv(clientcmd.RecommendedConfigPathEnvVar); len(p) > 0 {
-			configPath = p
-		} else {
-			configPath = clientcmd.RecommendedHomeFile
-		}
-		config, err = clientcmd.BuildConfigFromFlags("", configPath)
+	if len(conf.WatchLabelValues) == 0 {
+		return value, true
 	}
-
-	if err != nil {
-		err = fmt.Errorf("error building kubeconfig: %w", err)
-		return nil, err
-	}
-	return kubernetes.NewForConfig(config)
-}
-
-func client() *kubernetes.Clientset {
-	initClient.Do(func() {
-		var (
-			err error
-			c   *kubernetes.Clientset
-		)
-		defer func() {
-			if err != nil {
-				klog.Error(err.Error())
-				panic(err.Error())
-			}
-		}()
-
-		c, err = newClient()
-		if err != nil {
-			err = fmt.Errorf("error creating Kubernetes client: %w", err)
-			slog.Error(err.Error())
-			panic(err.Error())
-		}
-		_cli = c
-	})
-
-	return _cli
+	return value, slices.Contains(conf.WatchLabelValues, value)
 }
 
-func getHostAliasesFromServices(ctx context.Context) ([]corev1.HostAlias, error) {
-	services, err := client().CoreV1().Services("").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	if len(services.Items) == 0 {
-		return nil, nil
+// podSkipped reports whether the pod matches the configured skip selector or
+// carries one of the skip annotations.
+func podSkipped(pod *corev1.Pod) bool {
+	if conf.skipPodSelector != nil && conf.skipPodSelector.Matches(labels.Set(pod.Labels)) {
+		return true
 	}
-
-	hostAliases := make([]corev1.HostAlias, 0)
-
-	for _, service := range services.Items {
-		if service.Spec.Type != corev1.ServiceTypeClusterIP {
-			continue
+	for _, key := range conf.SkipPodAnnotations {
+		if _, ok := pod.Annotations[key]; ok {
+			return true
 		}
-		if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == "None" {
-			continue
-		}
-
-		domain1 := fmt.Sprintf("%s.%s.svc.cluster.local", service.GetName(), service.GetNamespace())
-		domain2 := fmt.Sprintf("%s.%s.svc", service.GetName(), service.GetNamespace())
-		domain3 := fmt.Sprintf("%s.%s", service.GetName(), service.GetNamespace())
-		hostAliases = append(hostAliases, corev1.HostAlias{
-			IP:        service.Spec.ClusterIP,
-			Hostnames: []string{domain1, domain2, domain3},
-		})
 	}
-
-	return hostAliases, nil
+	return false
 }
 
-func responseErrored(uid types.UID, code int32, err error) *v1.AdmissionResponse {
+// responseErrored rejects the request with the status code and reason of err.
+func responseErrored(uid types.UID, err error) *v1.AdmissionResponse {
+	code, reason := errorStatus(err)
 	return &v1.AdmissionResponse{
 		UID:     uid,
 		Allowed: false,
 		Result: &metav1.Status{
 			Code:    code,
+			Reason:  reason,
 			Message: err.Error(),
 		},
 	}
 }
 
-func responseAllowed(uid types.UID, msg string) *v1.AdmissionResponse {
+// responseAllowed allows the request unchanged. Warnings are shown to the
+// client, e.g. in kubectl output.
+func responseAllowed(uid types.UID, msg string, warnings ...string) *v1.AdmissionResponse {
 	return &v1.AdmissionResponse{
 		UID:     uid,
 		Allowed: true,
 		Result: &metav1.Status{
 			Message: msg,
 		},
+		Warnings: warnings,
+	}
+}
+
+// Decisions recorded in the decisionAuditAnnotation of allowed responses.
+const (
+	decisionSkippedNotPod      = "skipped-not-pod"
+	decisionSkippedOperation   = "skipped-operation"
+	decisionSkippedNamespace   = "skipped-namespace"
+	decisionSkippedDisabled    = "skipped-disabled"
+	decisionSkippedPredicate   = "skipped-predicate"
+	decisionSkippedNoLabels    = "skipped-no-labels"
+	decisionSkippedNotWatching = "skipped-not-watching"
+	decisionSkippedError       = "skipped-error"
+	decisionSkippedNoAliases   = "skipped-no-aliases"
+	decisionUnchanged          = "unchanged"
+	decisionMutated            = "mutated"
+)
+
+// decisionAuditAnnotation records why the webhook did or did not mutate a pod.
+// The API server prefixes it with the webhook name in the audit log.
+const decisionAuditAnnotation = "decision"
+
+// withDecision records decision in the response's audit annotations.
+func withDecision(r *v1.AdmissionResponse, decision string) *v1.AdmissionResponse {
+	if r.AuditAnnotations == nil {
+		r.AuditAnnotations = make(map[string]string)
 	}
+	r.AuditAnnotations[decisionAuditAnnotation] = decision
+	return r
 }
 
 func patchResponseFromRaw(uid types.UID, original, current []byte) *v1.AdmissionResponse {
 	patches, err := jsonpatch.CreatePatch(original, current)
 	if err != nil {
-		return responseErrored(uid, http.StatusInternalServerError, err)
+		return responseErrored(uid, patchError(err))
 	}
+	sortPatch(patches)
 
-	patchBytes := make([]byte, 0)
-	for _, p := range patches {
-		b, err := p.MarshalJSON()
-		if err != nil {
-			return responseErrored(uid, http.StatusInternalServerError, err)
+	if len(patches) == 0 {
+		return &v1.AdmissionResponse{
+			UID:     uid,
+			Allowed: true,
 		}
-		patchBytes = append(patchBytes, b...)
 	}
 
+	// A JSON Patch document is a single array of operations.
+	patchBytes, err := json.Marshal(patches)
+	if err != nil {
+		return responseErrored(uid, patchError(err))
+	}
+
+	pt := v1.PatchTypeJSONPatch
 	return &v1.AdmissionResponse{
-		UID:     uid,
-		Allowed: true,
-		Patch:   patchBytes,
-		PatchType: func() *v1.PatchType {
-			if len(patches) == 0 {
-				return nil
-			}
-			pt := v1.PatchTypeJSONPatch
-			return &pt
-		}(),
+		UID:       uid,
+		Allowed:   true,
+		Patch:     patchBytes,
+		PatchType: &pt,
+	}
+}
+
+// sortPatch orders patch operations by path. CreatePatch walks objects in map
+// order, so the same change could otherwise give a different patch each time.
+// Operations on one array keep their order, which their indices rely on.
+func sortPatch(patches []jsonpatch.Operation) {
+	slices.SortStableFunc(patches, func(a, b jsonpatch.Operation) int {
+		return strings.Compare(patchSortKey(a.Path), patchSortKey(b.Path))
+	})
+}
+
+// patchSortKey returns path up to its first array index.
+func patchSortKey(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if _, err := strconv.Atoi(segment); err == nil || segment == "-" {
+			return strings.Join(segments[:i], "/")
+		}
+	}
+	return path
+}
+
+var podResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+
+// isPodRequest reports whether the request carries a Pod object, so a
+// misconfigured webhook rule does not make us decode other kinds as pods.
+func isPodRequest(req *v1.AdmissionRequest) bool {
+	return req.Kind.Group == "" && req.Kind.Kind == "Pod" && req.Resource == podResource
+}
+
+// isDryRun reports whether the API server will discard the admission result.
+// Dry-run requests must not trigger side effects.
+func isDryRun(req *v1.AdmissionRequest) bool {
+	return req.DryRun != nil && *req.DryRun
+}
+
+// podDisplayName names a pod for logs. Pods being created often have only a
+// generateName, so the request UID is appended to it to tell them apart;
+// without either, the UID alone is used.
+func podDisplayName(pod *corev1.Pod, uid types.UID) string {
+	switch {
+	case pod.Name != "":
+		return pod.Name
+	case pod.GenerateName != "":
+		return pod.GenerateName + string(uid)
+	default:
+		return string(uid)
 	}
 }
 
 func mutatePods(ctx context.Context, req *v1.AdmissionReview) (response *v1.AdmissionResponse) {
+	if req.Request == nil {
+		return responseErrored("", errNoRequest)
+	}
 	uid := req.Request.UID
+	logger := slog.With("uid", uid, "namespace", req.Request.Namespace)
+
+	if !isPodRequest(req.Request) {
+		logger.Warn("ignoring request for a resource other than pods", "kind", req.Request.Kind.String(), "name", req.Request.Name, "outcome", outcomeNoop)
+		return withDecision(responseAllowed(uid, fmt.Sprintf("Ignoring %s, only pods are mutated", req.Request.Kind.Kind),
+			fmt.Sprintf("host-injector: received %s instead of a Pod, check the webhook rules", req.Request.Kind.String())),
+			decisionSkippedNotPod)
+	}
+
+	// The host aliases and DNS config of an existing pod cannot change, so
+	// only CREATE is mutated, whatever operations the webhook is registered
+	// for.
+	if req.Request.Operation != v1.Create {
+		logger.Debug("ignoring operation", "operation", req.Request.Operation, "name", req.Request.Name, "outcome", outcomeNoop)
+		return withDecision(responseAllowed(uid, fmt.Sprintf("Ignoring %s, only CREATE is mutated",
+			req.Request.Operation)), decisionSkippedOperation)
+	}
+
+	if slices.Contains(conf.SkipPodNamespaces, req.Request.Namespace) {
+		logger.Debug("ignoring pod in skipped namespace", "name", req.Request.Name, "outcome", outcomeNoop)
+		return withDecision(responseAllowed(uid, fmt.Sprintf("Namespace %s is skipped", req.Request.Namespace)),
+			decisionSkippedNamespace)
+	}
 
-	// Assuming the incoming request is of kind Pod
 	pod := corev1.Pod{}
 	if err := json.Unmarshal(req.Request.Object.Raw, &pod); err != nil {
-		responseErrored(uid, http.StatusBadRequest, err)
+		logger.Warn("failed to decode pod", "name", req.Request.Name, "outcome", outcomeErrored, "error", err)
+		return responseErrored(uid, decodeError(err))
+	}
+	logger = logger.With("pod", podDisplayName(&pod, uid))
+
+	if annotationEnabled(pod.GetAnnotations(), conf.PodDisableAnnotation) {
+		logger.Debug("injection disabled by annotation", "annotation", conf.PodDisableAnnotation, "outcome", outcomeNoop)
+		return withDecision(responseAllowed(uid, "Injection disabled by annotation"), decisionSkippedDisabled)
+	}
+	if podSkipped(&pod) {
+		logger.Debug("pod matches the skip predicate", "outcome", outcomeNoop)
+		return withDecision(responseAllowed(uid, "Pod matches the skip predicate"), decisionSkippedPredicate)
 	}
 
-	if !isWatching(&pod) {
-		return responseAllowed(uid, "Pod is not watching")
+	labelValue, watching := isWatching(&pod)
+	logger = logger.With("label", conf.WatchLabelKey, "labelValue", labelValue)
+	if !watching {
+		mutateStats.notWatching.Add(1)
+		// A pod without any labels usually means a template lost them, so
+		// it is told apart from one that just lacks the watch label.
+		if len(pod.Labels) == 0 {
+			logger.Debug("pod has no labels", "outcome", outcomeNoop)
+			return withDecision(responseAllowed(uid, "Pod has no labels",
+				fmt.Sprintf("host-injector: pod has no labels, label %q is required, no host aliases injected", conf.WatchLabelKey)),
+				decisionSkippedNoLabels)
+		}
+		logger.Debug("pod is not watching", "outcome", outcomeNoop)
+		return withDecision(responseAllowed(uid, "Pod is not watching",
+			fmt.Sprintf("host-injector: pod does not match label %q, no host aliases injected", conf.WatchLabelKey)),
+			decisionSkippedNotWatching)
+	}
+
+	// Only pods that need host aliases wait for the cache, so opted-out and
+	// unwatched pods are admitted while the injector starts.
+	if err := readiness.err(); err != nil {
+		if conf.FailureMode == failureModeOpen {
+			logger.Warn("admitting pod without host aliases before ready", "outcome", outcomeNoop, "error", err)
+			return withDecision(responseAllowed(uid, "Injector is not ready",
+				fmt.Sprintf("host-injector: %v, no host aliases injected", err)),
+				decisionSkippedError)
+		}
+		logger.Warn("rejecting admission before ready", "outcome", outcomeErrored, "error", err)
+		return responseErrored(uid, notReadyError(err))
 	}
 
-	hostAliases, err := getHostAliasesFromServices(ctx)
+	// pod.Namespace may be empty on create, so use the request's namespace.
+	scope := podAliasScope(&pod, req.Request.Namespace)
+	result, err := getHostAliasesFromServices(ctx, serviceLister, scope)
 	if err != nil {
 		err = fmt.Errorf("failed to get host aliases: %w", err)
-		return responseErrored(uid, http.StatusInternalServerError, err)
+		if conf.FailureMode == failureModeOpen {
+			logger.Warn("admitting pod without host aliases", "outcome", outcomeNoop, "error", err)
+			return withDecision(responseAllowed(uid, "Failed to get host aliases",
+				fmt.Sprintf("host-injector: %v, no host aliases injected", err)),
+				decisionSkippedError)
+		}
+		logger.Error("failed to get host aliases", "outcome", outcomeErrored, "error", err)
+		if !isDryRun(req.Request) {
+			recordInjectionFailure(req.Request.Namespace, podDisplayName(&pod, uid), err)
+		}
+		return responseErrored(uid, listError(err))
+	}
+
+	strategy, strategyErr := podInjectionStrategy(&pod)
+	if strategyErr != nil {
+		logger.Warn("ignoring invalid strategy annotation", "annotation", conf.PodStrategyAnnotation, "error", strategyErr)
+	}
+
+	// Overwrite still replaces the pod's own aliases when there is nothing
+	// to inject, so stale entries do not survive.
+	hostAliases := result.HostAliases
+	overwriting := strategy == injectionStrategyOverwrite && conf.InjectionMode != injectionModeDNSConfig && len(pod.Spec.HostAliases) > 0
+	if len(hostAliases) == 0 && !overwriting {
+		logger.Debug("no host aliases found", "outcome", outcomeNoop)
+		mutateStats.noAliases.Add(1)
+		return withDecision(responseAllowed(uid, "No host aliases found",
+			"host-injector: no services found to build host aliases from, no host aliases injected"),
+			decisionSkippedNoAliases)
 	}
 
-	if len(hostAliases) == 0 {
-		return responseAllowed(uid, "No host aliases found")
+	r := injectionResponse(uid, req.Request.Object.Raw, &pod, strategy, hostAliases, result.Services)
+	if r.Allowed && conf.MaxPatchBytes > 0 && len(r.Patch) > conf.MaxPatchBytes {
+		var kept int
+		r, kept = fitPatchSize(uid, req.Request.Object.Raw, &pod, strategy, result)
+		logger.Warn("patch too large, host aliases truncated", "limit", conf.MaxPatchBytes, "aliases", len(hostAliases), "kept", kept)
+		r.Warnings = append(r.Warnings, fmt.Sprintf(
+			"host-injector: patch exceeds %d bytes, injected %d of %d host aliases", conf.MaxPatchBytes, kept, len(hostAliases)))
+		hostAliases = hostAliases[:kept]
+	}
+	if r.Allowed {
+		r.Warnings = append(r.Warnings, result.Warnings...)
+		if len(r.Patch) > 0 && conf.InjectionMode != injectionModeDNSConfig && shadowsClusterDNS(hostAliases) {
+			r.Warnings = append(r.Warnings, fmt.Sprintf(
+				"host-injector: injected *.%s.%s host aliases shadow cluster DNS, a changed cluster IP is only picked up when the pod is recreated",
+				conf.ServiceDomain, conf.ClusterDomain))
+		}
+		if strategyErr != nil {
+			r.Warnings = append(r.Warnings, fmt.Sprintf(
+				"host-injector: %v in annotation %s, using %s", strategyErr, conf.PodStrategyAnnotation, strategy))
+		}
 	}
 
-	if pod.Spec.HostAliases == nil {
-		pod.Spec.HostAliases = make([]corev1.HostAlias, 0, len(hostAliases))
+	dryRun := isDryRun(req.Request)
+	if !dryRun {
+		injectedHostAliases.Set(float64(len(hostAliases)))
 	}
-	for _, hostAlias := range hostAliases {
-		pod.Spec.HostAliases = append(pod.Spec.HostAliases, hostAlias)
+	outcome := admissionOutcome(req.Request, r)
+	switch {
+	case !r.Allowed:
+		logger.Error("failed to create patch", "outcome", outcome, "error", r.Result.Message)
+	case dryRun:
+		logger.Info("computed host aliases for dry-run request", "outcome", outcome, "aliases", len(hostAliases))
+		r.Warnings = append(r.Warnings, "host-injector: dry-run request, injected host aliases are not persisted")
+		withDecision(r, decisionMutated)
+	case outcome == outcomeNoop:
+		logger.Debug("host aliases already present", "outcome", outcome, "aliases", len(hostAliases))
+		withDecision(r, decisionUnchanged)
+	default:
+		logger.Info("injected host aliases", "outcome", outcome, "aliases", len(hostAliases))
+		withDecision(r, decisionMutated)
 	}
+	return r
+}
 
-	resp, err := json.Marshal(pod)
-	if err != nil {
-		return responseErrored(uid, http.StatusInternalServerError, err)
+// injectionResponse returns the patch response that injects hostAliases, and
+// DNS searches for services, into a copy of pod following strategy. raw is
+// the pod as received.
+func injectionResponse(uid types.UID, raw []byte, pod *corev1.Pod, strategy string, hostAliases []corev1.HostAlias, services []types.NamespacedName) *v1.AdmissionResponse {
+	pod = pod.DeepCopy()
+	var capped []string
+	if conf.InjectionMode != injectionModeDNSConfig {
+		if conf.MaxHostnamesPerAlias > 0 {
+			hostAliases, capped = capHostnames(hostAliases, conf.MaxHostnamesPerAlias)
+		}
+		switch strategy {
+		case injectionStrategyPrepend:
+			pod.Spec.HostAliases = mergeHostAliases(hostAliases, pod.Spec.HostAliases)
+		case injectionStrategyOverwrite:
+			pod.Spec.HostAliases = mergeHostAliases(nil, hostAliases)
+		default:
+			pod.Spec.HostAliases = mergeHostAliases(pod.Spec.HostAliases, hostAliases)
+		}
+		if conf.SplitHostAliases {
+			pod.Spec.HostAliases = splitHostAliases(pod.Spec.HostAliases)
+		}
+	}
+	omittedSearches := 0
+	if conf.InjectionMode != injectionModeHostAliases {
+		omittedSearches = injectDNSSearches(pod, services)
+	}
+	if len(services) > 0 {
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, conf.InjectedFromAnnotation, injectedFrom(services))
+	}
+	if conf.AliasesHash {
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, conf.AliasesHashAnnotation, aliasesHash(hostAliases))
 	}
 
-	r := patchResponseFromRaw(uid, req.Request.Object.Raw, resp)
+	current, err := json.Marshal(pod)
+	if err != nil {
+		return responseErrored(uid, marshalError(fmt.Errorf("failed to encode pod: %w", err)))
+	}
+	r := patchResponseFromRaw(uid, raw, current)
+	if omittedSearches > 0 {
+		r.Warnings = append(r.Warnings, fmt.Sprintf(
+			"host-injector: %d DNS search domains omitted, pods allow at most %d search domains and %d characters",
+			omittedSearches, maxDNSSearches, maxDNSSearchListChars))
+	}
+	for _, ip := range capped {
+		r.Warnings = append(r.Warnings, fmt.Sprintf(
+			"host-injector: host alias for %s capped to %d hostnames, extra hostnames dropped", ip, conf.MaxHostnamesPerAlias))
+	}
 	return r
+}
 
+// maxInjectedFromLength bounds the injected-from annotation value, so pods
+// receiving aliases for many services do not grow without limit.
+const maxInjectedFromLength = 1024
+
+// injectedFrom formats services for the injected-from annotation. Services
+// that do not fit in maxInjectedFromLength are replaced by a count.
+func injectedFrom(services []types.NamespacedName) string {
+	// Leave room for the ",+N more" suffix.
+	const limit = maxInjectedFromLength - len(",+99999 more")
+
+	var b strings.Builder
+	for i, service := range services {
+		name := service.String()
+		if b.Len()+len(name)+1 > limit {
+			fmt.Fprintf(&b, ",+%d more", len(services)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+	}
+	return b.String()
 }
 
-func handleMutatePod(w http.ResponseWriter, r *http.Request) {
+// aliasesHash returns a SHA-256 checksum of the injected host aliases. They are
+// built in a stable order, so the same set always hashes the same.
+func aliasesHash(hostAliases []corev1.HostAlias) string {
+	// Marshalling a slice of plain structs cannot fail.
+	b, _ := json.Marshal(hostAliases)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// fitPatchSize returns the response injecting the longest prefix of the
+// result's host aliases whose patch fits in conf.MaxPatchBytes, and the length
+// of that prefix. The API server rejects patches that are too large, so a
+// partial set is better than none. Only the services whose aliases are kept
+// are recorded on the pod.
+func fitPatchSize(uid types.UID, raw []byte, pod *corev1.Pod, strategy string, result hostAliasResult) (*v1.AdmissionResponse, int) {
+	hostAliases := result.HostAliases
+	best, kept := injectionResponse(uid, raw, pod, strategy, nil, nil), 0
+	lo, hi := 1, len(hostAliases)-1
+	for lo <= hi {
+		n := (lo + hi) / 2
+		r := injectionResponse(uid, raw, pod, strategy, hostAliases[:n], result.servicesOf(hostAliases[:n]))
+		if r.Allowed && len(r.Patch) <= conf.MaxPatchBytes {
+			best, kept = r, n
+			lo = n + 1
+		} else {
+			hi = n - 1
+		}
+	}
+	return best, kept
+}
+
+// admissionReviewVersions are the AdmissionReview versions the webhook
+// accepts. v1beta1 has the same wire format as v1, so both decode into the v1
+// types.
+var admissionReviewVersions = []string{
+	v1.SchemeGroupVersion.String(),
+	v1beta1.SchemeGroupVersion.String(),
+}
+
+// admitFunc computes the response for a decoded admission review.
+type admitFunc func(ctx context.Context, req *v1.AdmissionReview) *v1.AdmissionResponse
+
+// serveAdmission decodes an AdmissionReview, passes it to admit and writes the
+// response review back.
+func serveAdmission(w http.ResponseWriter, r *http.Request, admit admitFunc) {
+	// The API server always POSTs admission reviews.
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "unsupported content type, expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, conf.MaxRequestBytes)
 
 	var admissionReview v1.AdmissionReview
 
 	if err := json.NewDecoder(r.Body).Decode(&admissionReview); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "could not decode request body", http.StatusBadRequest)
 		return
 	}
-	slog.Info("hello1")
-	admissionResponse := mutatePods(context.Background(), &admissionReview)
-	slog.Info("hello2")
-	admissionReview.Response = admissionResponse
+	if admissionReview.Request == nil {
+		http.Error(w, errNoRequest.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := admissionReview.APIVersion; v != "" && !slices.Contains(admissionReviewVersions, v) {
+		http.Error(w, fmt.Sprintf("unsupported AdmissionReview version %q", v), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), conf.AdmissionTimeout)
+	defer cancel()
+	admissionResponse := func() *v1.AdmissionResponse {
+		confMu.RLock()
+		defer confMu.RUnlock()
+		return admit(ctx, &admissionReview)
+	}()
+
+	// The API server expects the response to echo the request's apiVersion
+	// and kind, so v1beta1 requests get a v1beta1 response.
+	responseReview := v1.AdmissionReview{
+		TypeMeta: admissionReview.TypeMeta,
+		Response: admissionResponse,
+	}
+	if responseReview.APIVersion == "" || responseReview.Kind == "" {
+		responseReview.SetGroupVersionKind(v1.SchemeGroupVersion.WithKind("AdmissionReview"))
+	}
 
-	slog.Info("hello3")
-	if err := json.NewEncoder(w).Encode(admissionReview); err != nil {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(responseReview); err != nil {
 		http.Error(w, "could not encode response", http.StatusInternalServerError)
 		return
 	}
-	return
+}
+
+// mutate computes the response for a pod admission review. It is a variable
+// so tests can substitute an admission that panics.
+var mutate admitFunc = mutatePods
+
+// recoverMutatePods calls mutate, turning a panic into an errored response,
+// or an allowed one in fail-open mode, instead of crashing the server.
+func recoverMutatePods(ctx context.Context, req *v1.AdmissionReview) (resp *v1.AdmissionResponse) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		uid := req.Request.UID
+		err := panicError(p)
+		slog.Error("recovered from panic while mutating pod", "uid", uid, "namespace", req.Request.Namespace,
+			"outcome", outcomeErrored, "error", err, "stack", string(debug.Stack()))
+		if conf.FailureMode == failureModeOpen {
+			resp = withDecision(responseAllowed(uid, "Internal error",
+				"host-injector: internal error, no host aliases injected"), decisionSkippedError)
+			return
+		}
+		resp = responseErrored(uid, err)
+	}()
+	return mutate(ctx, req)
+}
+
+func handleMutatePod(w http.ResponseWriter, r *http.Request) {
+	serveAdmission(w, r, func(ctx context.Context, req *v1.AdmissionReview) *v1.AdmissionResponse {
+		start := time.Now()
+		resp := recoverMutatePods(ctx, req)
+		mutateDurationSeconds.Observe(time.Since(start).Seconds())
+		admissionRequestsTotal.WithLabelValues(admissionOutcome(req.Request, resp)).Inc()
+		admissionDecisionsTotal.WithLabelValues(admissionDecision(resp)).Inc()
+		mutateStats.record(req.Request, resp)
+		return resp
+	})
 }
 
 func main() {
-	http.HandleFunc("/mutate-core-v1-pod", handleMutatePod)
-	_ = http.ListenAndServeTLS(":9443", "testcerts/tls.crt", "testcerts/tls.key", nil)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		os.Exit(serve(args))
+	case "preview":
+		os.Exit(preview(args))
+	case "gen-webhook-config":
+		os.Exit(genWebhookConfig(args))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected serve, preview or gen-webhook-config\n", command)
+		os.Exit(2)
+	}
+}
+
+// setup loads the configuration and installs the logger shared by every
+// command.
+func setup(fs *flag.FlagSet, args []string) error {
+	c, err := loadConfig(fs, args)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	conf = c
+	aliasBuilds = newSemaphore(conf.MaxConcurrentBuilds)
+
+	logger, err := newLogger(os.Stderr, conf.LogLevel, conf.LogFormat)
+	if err != nil {
+		return fmt.Errorf("invalid logging configuration: %w", err)
+	}
+	setupLogging(logger)
+	return nil
+}
+
+// serve runs the webhook server and returns the process exit code.
+func serve(args []string) int {
+	if err := setup(flag.NewFlagSet("host-injector serve", flag.ExitOnError), args); err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+
+	info := buildInfo()
+	slog.Info("starting host-injector", "version", info.Version, "commit", info.Commit, "buildDate", info.BuildDate, "goVersion", info.GoVersion)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go reloadOnSIGHUP(ctx, args)
+
+	if err := run(ctx); err != nil {
+		slog.Error("server stopped", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// run serves the webhook and metrics listeners until ctx is cancelled, then
+// drains in-flight requests for up to the configured grace period.
+// newMetricsMux serves probes, metrics, stats and version. They are served in
+// plaintext so they need no client certificates; only the admission endpoints
+// use TLS.
+func newMetricsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/stats", handleStats)
+	mux.HandleFunc("/version", handleVersion)
+	return mux
+}
+
+// newWebhookMux serves the admission endpoints.
+func newWebhookMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(conf.MutatePath, handleMutatePod)
+	mux.HandleFunc(validatePath, handleValidatePod)
+	return mux
+}
+
+func run(ctx context.Context) error {
+	certs, err := newCertReloader(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := serverTLSConfig(certs)
+	if err != nil {
+		return err
+	}
+	go certs.watch(ctx, certReloadInterval)
+
+	go func() {
+		if err := initialize(ctx); err != nil {
+			slog.Error("failed to initialize", "error", err)
+		}
+	}()
+
+	metricsServer := &http.Server{
+		Addr:    conf.MetricsAddr,
+		Handler: newMetricsMux(),
+	}
+
+	server := &http.Server{
+		Addr:      conf.ListenAddr,
+		Handler:   newWebhookMux(),
+		TLSConfig: tlsConfig,
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- metricsServer.ListenAndServe()
+	}()
+	go func() {
+		errCh <- server.ListenAndServeTLS("", "")
+	}()
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down", "gracePeriod", conf.ShutdownGracePeriod.String())
+	case serveErr = <-errCh:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down webhook server", "error", err)
+	}
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down metrics server", "error", err)
+	}
+
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return nil
 }
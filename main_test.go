@@ -0,0 +1,1324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	applypatch "github.com/evanphx/json-patch"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// newTestLister returns a service lister over an indexer holding services.
+func newTestLister(t testing.TB, services ...*corev1.Service) corelisters.ServiceLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, service := range services {
+		if err := indexer.Add(service); err != nil {
+			t.Fatalf("adding service: %v", err)
+		}
+	}
+	return corelisters.NewServiceLister(indexer)
+}
+
+// failingServiceLister is a service lister whose every call fails with err.
+type failingServiceLister struct{ err error }
+
+func (l failingServiceLister) List(labels.Selector) ([]*corev1.Service, error) { return nil, l.err }
+
+func (l failingServiceLister) Services(string) corelisters.ServiceNamespaceLister { return l }
+
+func (l failingServiceLister) Get(string) (*corev1.Service, error) { return nil, l.err }
+
+// setupMutation serves services to mutatePods and marks the injector ready,
+// restoring the global state when the test ends.
+func setupMutation(t testing.TB, services ...*corev1.Service) {
+	t.Helper()
+	savedConf, savedLister := conf, serviceLister
+	t.Cleanup(func() {
+		conf, serviceLister = savedConf, savedLister
+		readiness.clientReady.Store(false)
+		readiness.cacheSynced.Store(false)
+		hostAliasCache.invalidate()
+	})
+	serviceLister = newTestLister(t, services...)
+	readiness.clientReady.Store(true)
+	readiness.cacheSynced.Store(true)
+	hostAliasCache.invalidate()
+}
+
+// setupConfig installs the default configuration changed by edit and
+// normalized, as loadConfig would, restoring the previous one afterwards.
+func setupConfig(t *testing.T, edit func(c *config)) {
+	t.Helper()
+	saved := conf
+	t.Cleanup(func() { conf = saved })
+	c := defaultConfig
+	edit(&c)
+	if err := c.normalize(); err != nil {
+		t.Fatalf("normalizing the configuration: %v", err)
+	}
+	conf = c
+}
+
+// setupEvents records failure events to a fake recorder as the leader.
+func setupEvents(t *testing.T) *record.FakeRecorder {
+	t.Helper()
+	savedRecorder := eventRecorder
+	t.Cleanup(func() {
+		eventRecorder = savedRecorder
+		leading.Store(false)
+	})
+	recorder := record.NewFakeRecorder(10)
+	eventRecorder = recorder
+	leading.Store(true)
+	return recorder
+}
+
+func testService(namespace, name, clusterIP string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.ServiceSpec{
+			Type:       corev1.ServiceTypeClusterIP,
+			ClusterIP:  clusterIP,
+			ClusterIPs: []string{clusterIP},
+			Ports:      []corev1.ServicePort{{Port: 80}},
+		},
+	}
+}
+
+// testPod returns a pod in namespace carrying the watch label.
+func testPod(namespace string, hostAliases ...corev1.HostAlias) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "app",
+			Labels:    map[string]string{defaultWatchLabelKey: "app"},
+		},
+		Spec: corev1.PodSpec{
+			Containers:  []corev1.Container{{Name: "app", Image: "app"}},
+			HostAliases: hostAliases,
+		},
+	}
+}
+
+// podReview returns a CREATE admission review for pod.
+func podReview(t *testing.T, pod *corev1.Pod, uid types.UID) *v1.AdmissionReview {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("encoding pod: %v", err)
+	}
+	return &v1.AdmissionReview{
+		Request: &v1.AdmissionRequest{
+			UID:       uid,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource:  podResource,
+			Namespace: pod.Namespace,
+			Operation: v1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+func TestMutatePodsPatchIsJSONArray(t *testing.T) {
+	setupMutation(t,
+		testService("default", "first", "10.0.0.1"),
+		testService("default", "second", "10.0.0.2"),
+	)
+
+	resp := mutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+	if !resp.Allowed || len(resp.Patch) == 0 {
+		t.Fatalf("expected an allowed response with a patch, got %+v", resp)
+	}
+	var ops []map[string]interface{}
+	if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+		t.Fatalf("patch %s is not a JSON array: %v", resp.Patch, err)
+	}
+	if len(ops) == 0 {
+		t.Fatal("patch has no operations")
+	}
+}
+
+func TestMutatePodsRejectsUndecodablePod(t *testing.T) {
+	setupMutation(t)
+
+	review := podReview(t, testPod("default"), "uid")
+	review.Request.Object.Raw = []byte("not a pod")
+	resp := mutatePods(context.Background(), review)
+	if resp.Allowed {
+		t.Fatal("expected the request to be rejected")
+	}
+	if resp.Result == nil || resp.Result.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d, got %+v", http.StatusBadRequest, resp.Result)
+	}
+}
+
+// applyPatch applies the JSON Patch in resp to pod and returns the result, so
+// tests can check the pod the API server would store.
+func applyPatch(t *testing.T, pod *corev1.Pod, resp *v1.AdmissionResponse) *corev1.Pod {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("encoding pod: %v", err)
+	}
+	if len(resp.Patch) > 0 {
+		patch, err := applypatch.DecodePatch(resp.Patch)
+		if err != nil {
+			t.Fatalf("decoding patch %s: %v", resp.Patch, err)
+		}
+		if raw, err = patch.Apply(raw); err != nil {
+			t.Fatalf("applying patch %s: %v", resp.Patch, err)
+		}
+	}
+	patched := &corev1.Pod{}
+	if err := json.Unmarshal(raw, patched); err != nil {
+		t.Fatalf("decoding patched pod: %v", err)
+	}
+	return patched
+}
+
+func TestMutatePodsMergesIntoExistingIP(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+
+	pod := testPod("default", corev1.HostAlias{IP: "10.0.0.1", Hostnames: []string{"custom"}})
+	resp := mutatePods(context.Background(), podReview(t, pod, "uid"))
+	patched := applyPatch(t, pod, resp)
+
+	want := []corev1.HostAlias{{
+		IP:        "10.0.0.1",
+		Hostnames: []string{"custom", "first.default.svc.cluster.local", "first.default.svc", "first.default"},
+	}}
+	if !reflect.DeepEqual(patched.Spec.HostAliases, want) {
+		t.Fatalf("host aliases = %+v, want %+v", patched.Spec.HostAliases, want)
+	}
+}
+
+func TestMutatePodsIsIdempotent(t *testing.T) {
+	setupMutation(t,
+		testService("default", "first", "10.0.0.1"),
+		testService("default", "second", "10.0.0.2"),
+	)
+
+	pod := testPod("default")
+	first := mutatePods(context.Background(), podReview(t, pod, "uid"))
+	if len(first.Patch) == 0 {
+		t.Fatal("first pass produced no patch")
+	}
+	mutated := applyPatch(t, pod, first)
+
+	second := mutatePods(context.Background(), podReview(t, mutated, "uid"))
+	if !second.Allowed || len(second.Patch) != 0 {
+		t.Fatalf("second pass produced patch %s", second.Patch)
+	}
+}
+
+func TestMutatePodsCapsDNSSearches(t *testing.T) {
+	var services []*corev1.Service
+	for i := 0; i < 40; i++ {
+		services = append(services, testService(fmt.Sprintf("ns%02d", i), "svc", fmt.Sprintf("10.0.1.%d", i+1)))
+	}
+	setupMutation(t, services...)
+	conf.InjectionMode = injectionModeDNSConfig
+	conf.ServiceScope = serviceScopeCluster
+
+	pod := testPod("ns00")
+	resp := mutatePods(context.Background(), podReview(t, pod, "uid"))
+	patched := applyPatch(t, pod, resp)
+
+	searches := patched.Spec.DNSConfig.Searches
+	if len(searches) != maxDNSSearches {
+		t.Fatalf("got %d search domains, want %d", len(searches), maxDNSSearches)
+	}
+	if searches[0] != "ns00.svc.cluster.local" {
+		t.Fatalf("first search domain = %q, want the pod's namespace", searches[0])
+	}
+	if !slices.ContainsFunc(resp.Warnings, func(w string) bool { return strings.Contains(w, "8 DNS search domains omitted") }) {
+		t.Fatalf("missing omitted search domains warning in %q", resp.Warnings)
+	}
+}
+
+func TestMutatePodsRecordsOnlyKeptServices(t *testing.T) {
+	var services []*corev1.Service
+	for i := 0; i < 50; i++ {
+		services = append(services, testService("default", fmt.Sprintf("svc%02d", i), fmt.Sprintf("10.0.1.%d", i+1)))
+	}
+	setupMutation(t, services...)
+	conf.MaxPatchBytes = 2000
+
+	pod := testPod("default")
+	resp := mutatePods(context.Background(), podReview(t, pod, "uid"))
+	patched := applyPatch(t, pod, resp)
+
+	kept := len(patched.Spec.HostAliases)
+	if kept == 0 || kept == len(services) {
+		t.Fatalf("expected the aliases to be truncated, kept %d", kept)
+	}
+	recorded := strings.Split(patched.Annotations[defaultInjectedFromAnnotation], ",")
+	if len(recorded) != kept {
+		t.Fatalf("annotation records %d services for %d host aliases: %q", len(recorded), kept, recorded)
+	}
+	for i, hostAlias := range patched.Spec.HostAliases {
+		if want := "default/" + strings.SplitN(hostAlias.Hostnames[0], ".", 2)[0]; recorded[i] != want {
+			t.Fatalf("annotation entry %d = %q, want %q", i, recorded[i], want)
+		}
+	}
+}
+
+// encodeReview returns review as JSON.
+func encodeReview(t *testing.T, review any) []byte {
+	t.Helper()
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatalf("encoding review: %v", err)
+	}
+	return body
+}
+
+// postReview POSTs body as JSON to handleMutatePod.
+func postReview(t *testing.T, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, conf.MutatePath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handleMutatePod(rec, req)
+	return rec
+}
+
+// decodeReview decodes the response review recorded in rec.
+func decodeReview(t *testing.T, rec *httptest.ResponseRecorder) v1.AdmissionReview {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var review v1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	return review
+}
+
+func TestHandleMutatePodEchoesUID(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+
+	notWatching := testPod("default")
+	notWatching.Labels = map[string]string{"other": "label"}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		decision string
+	}{
+		{"not watching", notWatching, decisionSkippedNotWatching},
+		{"no aliases", testPod("empty"), decisionSkippedNoAliases},
+		{"mutated", testPod("default"), decisionMutated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid := types.UID("uid-" + strings.ReplaceAll(tt.name, " ", "-"))
+			review := decodeReview(t, postReview(t, encodeReview(t, podReview(t, tt.pod, uid))))
+			if review.Response == nil || review.Response.UID != uid {
+				t.Fatalf("response UID does not match request UID %q: %+v", uid, review.Response)
+			}
+			if got := review.Response.AuditAnnotations[decisionAuditAnnotation]; got != tt.decision {
+				t.Fatalf("decision = %q, want %q", got, tt.decision)
+			}
+		})
+	}
+}
+
+func TestMutatePodsOverwriteRemovesStaleAliases(t *testing.T) {
+	stale := corev1.HostAlias{IP: "10.9.9.9", Hostnames: []string{"stale"}}
+	tests := []struct {
+		name     string
+		services []*corev1.Service
+		want     []corev1.HostAlias
+	}{
+		{
+			name:     "with services",
+			services: []*corev1.Service{testService("default", "first", "10.0.0.1")},
+			want: []corev1.HostAlias{{
+				IP:        "10.0.0.1",
+				Hostnames: []string{"first.default.svc.cluster.local", "first.default.svc", "first.default"},
+			}},
+		},
+		{
+			name: "without services",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, tt.services...)
+			conf.InjectionStrategy = injectionStrategyOverwrite
+
+			pod := testPod("default", stale)
+			resp := mutatePods(context.Background(), podReview(t, pod, "uid"))
+			patched := applyPatch(t, pod, resp)
+			if !reflect.DeepEqual(patched.Spec.HostAliases, tt.want) {
+				t.Fatalf("host aliases = %+v, want %+v", patched.Spec.HostAliases, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutatePodsPutsExternalIPsLast(t *testing.T) {
+	service := testService("default", "first", "10.0.0.2")
+	service.Spec.ExternalIPs = []string{"1.2.3.4"}
+	for _, localFirst := range []bool{false, true} {
+		t.Run(fmt.Sprintf("local services first %t", localFirst), func(t *testing.T) {
+			setupMutation(t, service)
+			conf.ExternalIPAliases = true
+			conf.LocalServicesFirst = localFirst
+
+			pod := testPod("default")
+			patched := applyPatch(t, pod, mutatePods(context.Background(), podReview(t, pod, "uid")))
+			var ips []string
+			for _, hostAlias := range patched.Spec.HostAliases {
+				ips = append(ips, hostAlias.IP)
+			}
+			if want := []string{"10.0.0.2", "1.2.3.4"}; !slices.Equal(ips, want) {
+				t.Fatalf("host alias IPs = %q, want %q", ips, want)
+			}
+		})
+	}
+}
+
+func TestMutatePodsCapsOnlyInjectedHostnames(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	conf.MaxHostnamesPerAlias = 2
+	conf.SplitHostAliases = true
+
+	pod := testPod("default", corev1.HostAlias{IP: "10.9.9.9", Hostnames: []string{"a", "b", "c"}})
+	first := mutatePods(context.Background(), podReview(t, pod, "uid"))
+	if !slices.ContainsFunc(first.Warnings, func(w string) bool { return strings.Contains(w, "10.0.0.1 capped") }) {
+		t.Fatalf("expected a warning for the capped IP, got %q", first.Warnings)
+	}
+	mutated := applyPatch(t, pod, first)
+	want := []corev1.HostAlias{
+		{IP: "10.9.9.9", Hostnames: []string{"a"}},
+		{IP: "10.9.9.9", Hostnames: []string{"b"}},
+		{IP: "10.9.9.9", Hostnames: []string{"c"}},
+		{IP: "10.0.0.1", Hostnames: []string{"first.default.svc.cluster.local"}},
+		{IP: "10.0.0.1", Hostnames: []string{"first.default.svc"}},
+	}
+	if !reflect.DeepEqual(mutated.Spec.HostAliases, want) {
+		t.Fatalf("host aliases = %+v, want %+v", mutated.Spec.HostAliases, want)
+	}
+
+	second := mutatePods(context.Background(), podReview(t, mutated, "uid"))
+	if !second.Allowed || len(second.Patch) != 0 {
+		t.Fatalf("second pass produced patch %s", second.Patch)
+	}
+}
+
+func TestMutatePodsSharesCacheAcrossOwnServiceLabels(t *testing.T) {
+	own := testService("default", "web", "10.0.0.1")
+	own.Spec.Selector = map[string]string{"app": "web"}
+	setupMutation(t, own, testService("default", "other", "10.0.0.2"))
+	conf.ExcludeOwnServices = true
+
+	for _, hash := range []string{"a", "b"} {
+		pod := testPod("default")
+		pod.Labels["app"] = "web"
+		pod.Labels["pod-template-hash"] = hash
+		patched := applyPatch(t, pod, mutatePods(context.Background(), podReview(t, pod, "uid")))
+		var ips []string
+		for _, hostAlias := range patched.Spec.HostAliases {
+			ips = append(ips, hostAlias.IP)
+		}
+		if want := []string{"10.0.0.2"}; !slices.Equal(ips, want) {
+			t.Fatalf("host alias IPs = %q, want %q", ips, want)
+		}
+	}
+	if n := len(hostAliasCache.entries); n != 1 {
+		t.Fatalf("alias cache holds %d entries, want 1", n)
+	}
+}
+
+func TestMutatePodsFailureMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		ready      bool
+		wantReason metav1.StatusReason
+	}{
+		{name: "list error, fail-closed", mode: failureModeClosed, ready: true, wantReason: reasonListFailed},
+		{name: "list error, fail-open", mode: failureModeOpen, ready: true},
+		{name: "not ready, fail-closed", mode: failureModeClosed, wantReason: reasonNotReady},
+		{name: "not ready, fail-open", mode: failureModeOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t)
+			serviceLister = failingServiceLister{errors.New("connection refused")}
+			readiness.cacheSynced.Store(tt.ready)
+			conf.FailureMode = tt.mode
+
+			resp := mutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+			if tt.wantReason != "" {
+				if resp.Allowed || resp.Result == nil || resp.Result.Reason != tt.wantReason {
+					t.Fatalf("expected a rejection with reason %q, got %+v", tt.wantReason, resp)
+				}
+				return
+			}
+			if !resp.Allowed || len(resp.Patch) != 0 || len(resp.Warnings) == 0 {
+				t.Fatalf("expected an allowed response without a patch and with a warning, got %+v", resp)
+			}
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; got != decisionSkippedError {
+				t.Fatalf("decision = %q, want %q", got, decisionSkippedError)
+			}
+		})
+	}
+}
+
+func TestMutatePodsAdmitsSkippedPodsBeforeReady(t *testing.T) {
+	disabled := testPod("default")
+	disabled.Annotations = map[string]string{conf.PodDisableAnnotation: "true"}
+	unwatched := testPod("default")
+	unwatched.Labels = map[string]string{"other": "label"}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		decision string
+	}{
+		{"disabled", disabled, decisionSkippedDisabled},
+		{"not watching", unwatched, decisionSkippedNotWatching},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t)
+			readiness.cacheSynced.Store(false)
+
+			resp := mutatePods(context.Background(), podReview(t, tt.pod, "uid"))
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != tt.decision {
+				t.Fatalf("expected an allowed response with decision %q, got %+v", tt.decision, resp)
+			}
+		})
+	}
+
+	t.Run("watched", func(t *testing.T) {
+		setupMutation(t)
+		readiness.cacheSynced.Store(false)
+
+		resp := mutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+		if resp.Allowed || resp.Result.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected a 503 rejection, got %+v", resp)
+		}
+	})
+}
+
+func TestMutatePodsOperations(t *testing.T) {
+	tests := []struct {
+		operation v1.Operation
+		decision  string
+	}{
+		{v1.Create, decisionMutated},
+		{v1.Update, decisionSkippedOperation},
+		{v1.Delete, decisionSkippedOperation},
+		{v1.Connect, decisionSkippedOperation},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.operation), func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+
+			review := podReview(t, testPod("default"), "uid")
+			review.Request.Operation = tt.operation
+			resp := mutatePods(context.Background(), review)
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != tt.decision {
+				t.Fatalf("expected an allowed response with decision %q, got %+v", tt.decision, resp)
+			}
+			if tt.decision == decisionSkippedOperation && len(resp.Patch) != 0 {
+				t.Fatalf("%s produced patch %s", tt.operation, resp.Patch)
+			}
+		})
+	}
+}
+
+func TestMutatePodsSkipPodNamespaces(t *testing.T) {
+	// The pod's own namespace is often empty on create, so the request's
+	// namespace decides.
+	unset := testPod("")
+	tests := []struct {
+		name      string
+		pod       *corev1.Pod
+		namespace string
+		decision  string
+	}{
+		{"skipped namespace", testPod("kube-system"), "kube-system", decisionSkippedNamespace},
+		{"namespace from request", unset, "kube-system", decisionSkippedNamespace},
+		{"other namespace", testPod("default"), "default", decisionMutated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"), testService("kube-system", "dns", "10.0.0.10"))
+			conf.SkipPodNamespaces = []string{"kube-system"}
+
+			review := podReview(t, tt.pod, "uid")
+			review.Request.Namespace = tt.namespace
+			resp := mutatePods(context.Background(), review)
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != tt.decision {
+				t.Fatalf("expected an allowed response with decision %q, got %+v", tt.decision, resp)
+			}
+			if tt.decision == decisionSkippedNamespace && len(resp.Patch) != 0 {
+				t.Fatalf("pod in skipped namespace got patch %s", resp.Patch)
+			}
+		})
+	}
+}
+
+func TestRecoverMutatePodsPanic(t *testing.T) {
+	saved := mutate
+	t.Cleanup(func() { mutate = saved })
+	mutate = func(context.Context, *v1.AdmissionReview) *v1.AdmissionResponse {
+		panic("boom")
+	}
+	tests := []struct {
+		mode       string
+		wantReason metav1.StatusReason
+	}{
+		{mode: failureModeClosed, wantReason: reasonPanic},
+		{mode: failureModeOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			setupMutation(t)
+			conf.FailureMode = tt.mode
+
+			resp := recoverMutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+			if resp.UID != "uid" {
+				t.Fatalf("response UID = %q, want %q", resp.UID, "uid")
+			}
+			if tt.wantReason != "" {
+				if resp.Allowed || resp.Result == nil || resp.Result.Reason != tt.wantReason {
+					t.Fatalf("expected a rejection with reason %q, got %+v", tt.wantReason, resp)
+				}
+				return
+			}
+			if !resp.Allowed || len(resp.Patch) != 0 || len(resp.Warnings) == 0 {
+				t.Fatalf("expected an allowed response without a patch and with a warning, got %+v", resp)
+			}
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; got != decisionSkippedError {
+				t.Fatalf("decision = %q, want %q", got, decisionSkippedError)
+			}
+		})
+	}
+}
+
+func TestMutatePodsDryRun(t *testing.T) {
+	dryRun := true
+	t.Run("mutated", func(t *testing.T) {
+		setupMutation(t, testService("default", "first", "10.0.0.1"))
+		recorder := setupEvents(t)
+		injectedHostAliases.Set(42)
+
+		review := podReview(t, testPod("default"), "uid")
+		review.Request.DryRun = &dryRun
+		resp := mutatePods(context.Background(), review)
+		if !resp.Allowed || len(resp.Patch) == 0 {
+			t.Fatalf("expected an allowed response with a patch, got %+v", resp)
+		}
+		if got := applyPatch(t, testPod("default"), resp).Spec.HostAliases; len(got) != 1 || got[0].IP != "10.0.0.1" {
+			t.Fatalf("patched host aliases = %+v, want the service's", got)
+		}
+		if !slices.Contains(resp.Warnings, "host-injector: dry-run request, injected host aliases are not persisted") {
+			t.Fatalf("warnings = %q, want the dry-run warning", resp.Warnings)
+		}
+		if got := admissionOutcome(review.Request, resp); got != outcomeDryRun {
+			t.Fatalf("outcome = %q, want %q", got, outcomeDryRun)
+		}
+		if got := testutil.ToFloat64(injectedHostAliases); got != 42 {
+			t.Fatalf("injected host aliases gauge = %v, want it untouched", got)
+		}
+		if len(recorder.Events) != 0 {
+			t.Fatalf("dry-run recorded event %q", <-recorder.Events)
+		}
+	})
+	t.Run("list error", func(t *testing.T) {
+		setupMutation(t)
+		recorder := setupEvents(t)
+		serviceLister = failingServiceLister{errors.New("connection refused")}
+
+		review := podReview(t, testPod("default"), "uid")
+		review.Request.DryRun = &dryRun
+		if resp := mutatePods(context.Background(), review); resp.Allowed {
+			t.Fatalf("expected a rejection, got %+v", resp)
+		}
+		if len(recorder.Events) != 0 {
+			t.Fatalf("dry-run recorded event %q", <-recorder.Events)
+		}
+	})
+}
+
+func TestHandleMutatePodEchoesTypeMeta(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	tests := []struct {
+		name string
+		in   metav1.TypeMeta
+		want metav1.TypeMeta
+	}{
+		{
+			name: "set",
+			in:   metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			want: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		},
+		{
+			name: "v1beta1",
+			in:   metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+			want: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		},
+		{
+			name: "unset",
+			want: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := podReview(t, testPod("default"), "uid")
+			request.TypeMeta = tt.in
+			if got := decodeReview(t, postReview(t, encodeReview(t, request))).TypeMeta; got != tt.want {
+				t.Fatalf("response TypeMeta = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown version", func(t *testing.T) {
+		request := podReview(t, testPod("default"), "uid")
+		request.TypeMeta = metav1.TypeMeta{APIVersion: "admission.k8s.io/v2", Kind: "AdmissionReview"}
+		if rec := postReview(t, encodeReview(t, request)); rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestMutatePodsDisableAnnotation(t *testing.T) {
+	tests := []struct {
+		value    string
+		decision string
+	}{
+		{"true", decisionSkippedDisabled},
+		{"false", decisionMutated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+			pod := testPod("default")
+			pod.Annotations = map[string]string{"host-injector/disable": tt.value}
+
+			resp := mutatePods(context.Background(), podReview(t, pod, "uid"))
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != tt.decision {
+				t.Fatalf("expected an allowed response with decision %q, got %+v", tt.decision, resp)
+			}
+			if tt.decision == decisionSkippedDisabled && len(resp.Patch) != 0 {
+				t.Fatalf("disabled pod got patch %s", resp.Patch)
+			}
+		})
+	}
+}
+
+func TestMutatePodsIgnoresOtherKinds(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	review := podReview(t, testPod("default"), "uid")
+	review.Request.Kind = metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	review.Request.Resource = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+	resp := mutatePods(context.Background(), review)
+	if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != decisionSkippedNotPod {
+		t.Fatalf("expected an allowed response with decision %q, got %+v", decisionSkippedNotPod, resp)
+	}
+	if len(resp.Patch) != 0 {
+		t.Fatalf("deployment got patch %s", resp.Patch)
+	}
+	want := "host-injector: received apps/v1, Kind=Deployment instead of a Pod, check the webhook rules"
+	if !slices.Equal(resp.Warnings, []string{want}) {
+		t.Fatalf("warnings = %q, want %q", resp.Warnings, want)
+	}
+}
+
+func TestMutatePodsSkipWarnings(t *testing.T) {
+	notWatching := testPod("default")
+	notWatching.Labels = map[string]string{"other": "label"}
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		warning string
+	}{
+		{"not watching", notWatching, `host-injector: pod does not match label "k8s-app", no host aliases injected`},
+		{"no aliases", testPod("empty"), "host-injector: no services found to build host aliases from, no host aliases injected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+
+			resp := mutatePods(context.Background(), podReview(t, tt.pod, "uid"))
+			if !resp.Allowed || len(resp.Patch) != 0 {
+				t.Fatalf("expected an allowed response without a patch, got %+v", resp)
+			}
+			if !slices.Equal(resp.Warnings, []string{tt.warning}) {
+				t.Fatalf("warnings = %q, want %q", resp.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+// orderedServiceLister is a service lister returning services in the given
+// order, unlike an indexer, whose order is random.
+type orderedServiceLister []*corev1.Service
+
+func (l orderedServiceLister) List(labels.Selector) ([]*corev1.Service, error) {
+	return slices.Clone(l), nil
+}
+
+func (l orderedServiceLister) Services(namespace string) corelisters.ServiceNamespaceLister {
+	return orderedServiceLister(slices.DeleteFunc(slices.Clone(l), func(service *corev1.Service) bool {
+		return service.Namespace != namespace
+	}))
+}
+
+func (l orderedServiceLister) Get(name string) (*corev1.Service, error) {
+	for _, service := range l {
+		if service.Name == name {
+			return service, nil
+		}
+	}
+	return nil, fmt.Errorf("service %q not found", name)
+}
+
+func TestMutatePodsPatchIsDeterministic(t *testing.T) {
+	dualStack := testService("default", "dual", "10.0.0.3")
+	dualStack.Spec.ClusterIPs = []string{"10.0.0.3", "fd00::3"}
+	services := []*corev1.Service{
+		testService("default", "first", "10.0.0.1"),
+		testService("default", "second", "10.0.0.2"),
+		dualStack,
+		testService("other", "first", "10.0.1.1"),
+	}
+	reversed := slices.Clone(services)
+	slices.Reverse(reversed)
+
+	var patches [][]byte
+	for _, order := range [][]*corev1.Service{services, reversed} {
+		setupMutation(t)
+		conf.ServiceScope = serviceScopeCluster
+		serviceLister = orderedServiceLister(order)
+
+		resp := mutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+		if !resp.Allowed || len(resp.Patch) == 0 {
+			t.Fatalf("expected an allowed response with a patch, got %+v", resp)
+		}
+		patches = append(patches, resp.Patch)
+	}
+	if !bytes.Equal(patches[0], patches[1]) {
+		t.Fatalf("patches differ with the lister order:\n%s\n%s", patches[0], patches[1])
+	}
+}
+
+func TestHandleMutatePodTimeout(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	conf.AdmissionTimeout = 20 * time.Millisecond
+	savedBuilds := aliasBuilds
+	t.Cleanup(func() { aliasBuilds = savedBuilds })
+	// Hold the only build slot, so the admission waits until it times out.
+	aliasBuilds = newSemaphore(1)
+	if err := aliasBuilds.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(aliasBuilds.release)
+
+	review := decodeReview(t, postReview(t, encodeReview(t, podReview(t, testPod("default"), "uid"))))
+	resp := review.Response
+	if resp.Allowed || resp.Result == nil {
+		t.Fatalf("expected a rejection, got %+v", resp)
+	}
+	if resp.Result.Code != http.StatusGatewayTimeout || resp.Result.Reason != reasonListFailed {
+		t.Fatalf("result = %d %s, want %d %s", resp.Result.Code, resp.Result.Reason, http.StatusGatewayTimeout, reasonListFailed)
+	}
+}
+
+func TestHandleMutatePodRejectsReviewWithoutRequest(t *testing.T) {
+	setupMutation(t)
+	rec := postReview(t, []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMutatePodsStrategyOrder(t *testing.T) {
+	own := corev1.HostAlias{IP: "10.9.9.9", Hostnames: []string{"own"}}
+	injected := corev1.HostAlias{IP: "10.0.0.1", Hostnames: []string{"first.default.svc.cluster.local", "first.default.svc", "first.default"}}
+	tests := []struct {
+		strategy string
+		want     []corev1.HostAlias
+	}{
+		{injectionStrategyAppend, []corev1.HostAlias{own, injected}},
+		{injectionStrategyPrepend, []corev1.HostAlias{injected, own}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+			pod := testPod("default", own)
+			pod.Annotations = map[string]string{conf.PodStrategyAnnotation: tt.strategy}
+
+			resp := mutatePods(context.Background(), podReview(t, pod, "uid"))
+			if got := applyPatch(t, pod, resp).Spec.HostAliases; !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("host aliases = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleMutatePodRejectsLargeBodies(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	body := encodeReview(t, podReview(t, testPod("default"), "uid"))
+	conf.MaxRequestBytes = int64(len(body) - 1)
+
+	if rec := postReview(t, body); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	conf.MaxRequestBytes = int64(len(body))
+	decodeReview(t, postReview(t, body))
+}
+
+func TestHandleMutatePodRejectsGet(t *testing.T) {
+	setupMutation(t)
+	rec := httptest.NewRecorder()
+	handleMutatePod(rec, httptest.NewRequest(http.MethodGet, conf.MutatePath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := rec.Header().Get("Allow"); got != http.MethodPost {
+		t.Fatalf("Allow = %q, want %q", got, http.MethodPost)
+	}
+}
+
+func TestHandleMutatePodRejectsOtherContentTypes(t *testing.T) {
+	setupMutation(t)
+	req := httptest.NewRequest(http.MethodPost, conf.MutatePath, bytes.NewReader(encodeReview(t, podReview(t, testPod("default"), "uid"))))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	handleMutatePod(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestPodDisplayName(t *testing.T) {
+	tests := []struct {
+		name         string
+		generateName string
+		want         string
+	}{
+		{"app", "app-", "app"},
+		{"", "app-", "app-uid"},
+		{"", "", "uid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: tt.name, GenerateName: tt.generateName}}
+			if got := podDisplayName(pod, "uid"); got != tt.want {
+				t.Fatalf("podDisplayName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("generated pod", func(t *testing.T) {
+		setupMutation(t, testService("default", "first", "10.0.0.1"))
+		var buf bytes.Buffer
+		savedLogger := slog.Default()
+		t.Cleanup(func() { slog.SetDefault(savedLogger) })
+		slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+		pod := testPod("default")
+		pod.Name, pod.GenerateName = "", "app-"
+		if resp := mutatePods(context.Background(), podReview(t, pod, "uid")); !resp.Allowed || len(resp.Patch) == 0 {
+			t.Fatalf("expected an allowed response with a patch, got %+v", resp)
+		}
+		if !strings.Contains(buf.String(), "pod=app-uid") {
+			t.Fatalf("log %q does not name the pod app-uid", buf.String())
+		}
+	})
+}
+
+func TestMutatePodsSkipPredicate(t *testing.T) {
+	job := testPod("default")
+	job.Labels["job-name"] = "migrate"
+	sidecar := testPod("default")
+	sidecar.Annotations = map[string]string{"sidecar.istio.io/inject": "false"}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		decision string
+	}{
+		{"selector", job, decisionSkippedPredicate},
+		{"annotation", sidecar, decisionSkippedPredicate},
+		{"neither", testPod("default"), decisionMutated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+			setupConfig(t, func(c *config) {
+				c.SkipPodSelector = "job-name"
+				c.SkipPodAnnotations = []string{"sidecar.istio.io/inject"}
+			})
+
+			resp := mutatePods(context.Background(), podReview(t, tt.pod, "uid"))
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != tt.decision {
+				t.Fatalf("expected an allowed response with decision %q, got %+v", tt.decision, resp)
+			}
+			if tt.decision == decisionSkippedPredicate && len(resp.Patch) != 0 {
+				t.Fatalf("skipped pod got patch %s", resp.Patch)
+			}
+		})
+	}
+}
+
+func TestMutatePodsDecisions(t *testing.T) {
+	service := testService("default", "first", "10.0.0.1")
+	withAnnotation := func(key string) *corev1.Pod {
+		pod := testPod("default")
+		pod.Annotations = map[string]string{key: "true"}
+		return pod
+	}
+	unlabeled := testPod("default")
+	unlabeled.Labels = nil
+	unwatched := testPod("default")
+	unwatched.Labels = map[string]string{"other": "label"}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		edit     func(*v1.AdmissionRequest)
+		setup    func(t *testing.T)
+		decision string
+	}{
+		{
+			name: "not a pod",
+			pod:  testPod("default"),
+			edit: func(r *v1.AdmissionRequest) {
+				r.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+				r.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+			},
+			decision: decisionSkippedNotPod,
+		},
+		{name: "operation", pod: testPod("default"), edit: func(r *v1.AdmissionRequest) { r.Operation = v1.Update }, decision: decisionSkippedOperation},
+		{name: "namespace", pod: testPod("kube-system"), setup: func(*testing.T) { conf.SkipPodNamespaces = []string{"kube-system"} }, decision: decisionSkippedNamespace},
+		{name: "disabled", pod: withAnnotation(conf.PodDisableAnnotation), decision: decisionSkippedDisabled},
+		{name: "predicate", pod: withAnnotation("skip.example.com/me"), setup: func(*testing.T) { conf.SkipPodAnnotations = []string{"skip.example.com/me"} }, decision: decisionSkippedPredicate},
+		{name: "no labels", pod: unlabeled, decision: decisionSkippedNoLabels},
+		{name: "not watching", pod: unwatched, decision: decisionSkippedNotWatching},
+		{
+			name: "error",
+			pod:  testPod("default"),
+			setup: func(*testing.T) {
+				serviceLister = failingServiceLister{errors.New("connection refused")}
+				conf.FailureMode = failureModeOpen
+			},
+			decision: decisionSkippedError,
+		},
+		{name: "no aliases", pod: testPod("empty"), decision: decisionSkippedNoAliases},
+		{
+			name: "unchanged",
+			// The pod as the mutation left it needs no further patch.
+			pod:      applyPatch(t, testPod("default"), mutatePodsWith(t, service, testPod("default"))),
+			decision: decisionUnchanged,
+		},
+		{name: "mutated", pod: testPod("default"), decision: decisionMutated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, service)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+
+			review := podReview(t, tt.pod, "uid")
+			if tt.edit != nil {
+				tt.edit(review.Request)
+			}
+			resp := mutatePods(context.Background(), review)
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != tt.decision {
+				t.Fatalf("expected an allowed response with decision %q, got %+v", tt.decision, resp)
+			}
+			if tt.decision != decisionMutated && len(resp.Patch) != 0 {
+				t.Fatalf("decision %q came with patch %s", tt.decision, resp.Patch)
+			}
+		})
+	}
+}
+
+// mutatePodsWith mutates pod with service as the only service.
+func mutatePodsWith(t *testing.T, service *corev1.Service, pod *corev1.Pod) *v1.AdmissionResponse {
+	t.Helper()
+	setupMutation(t, service)
+	return mutatePods(context.Background(), podReview(t, pod, "uid"))
+}
+
+func TestMutatePodsMissingWatchLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		message  string
+		warning  string
+		decision string
+	}{
+		{"nil labels", nil, "Pod has no labels", "pod has no labels", decisionSkippedNoLabels},
+		{"empty labels", map[string]string{}, "Pod has no labels", "pod has no labels", decisionSkippedNoLabels},
+		{"labels without the key", map[string]string{"app": "web"}, "Pod is not watching", "pod does not match label", decisionSkippedNotWatching},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+
+			pod := testPod("default")
+			pod.Labels = tt.labels
+			resp := mutatePods(context.Background(), podReview(t, pod, "uid"))
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != tt.decision {
+				t.Fatalf("expected an allowed response with decision %q, got %+v", tt.decision, resp)
+			}
+			if resp.Result == nil || resp.Result.Message != tt.message {
+				t.Errorf("message = %+v, want %q", resp.Result, tt.message)
+			}
+			if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], tt.warning) {
+				t.Errorf("warnings = %q, want one containing %q", resp.Warnings, tt.warning)
+			}
+			if len(resp.Patch) != 0 {
+				t.Errorf("unexpected patch %s", resp.Patch)
+			}
+		})
+	}
+}
+
+func TestMutatePodsShadowWarning(t *testing.T) {
+	const warning = "host-injector: injected *.svc.cluster.local host aliases shadow cluster DNS, a changed cluster IP is only picked up when the pod is recreated"
+	tests := []struct {
+		forms []string
+		want  int
+	}{
+		// The warning is given once however many services are injected.
+		{[]string{hostnameFormFQDN, hostnameFormSvc, hostnameFormShort}, 1},
+		{[]string{hostnameFormFQDN}, 1},
+		{[]string{hostnameFormShort}, 0},
+		{[]string{hostnameFormSvc, hostnameFormShort}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.forms, ","), func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"), testService("default", "second", "10.0.0.2"))
+			conf.HostnameForms = tt.forms
+
+			resp := mutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+			if got := resp.AuditAnnotations[decisionAuditAnnotation]; !resp.Allowed || got != decisionMutated {
+				t.Fatalf("expected a mutated response, got %+v", resp)
+			}
+			var got int
+			for _, w := range resp.Warnings {
+				if w == warning {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Fatalf("shadowing warning given %d times, want %d: %q", got, tt.want, resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestHandleMutatePodDecisionMetrics(t *testing.T) {
+	unwatched := testPod("default")
+	unwatched.Labels = map[string]string{"other": "label"}
+	decisions := []string{decisionSkippedNotWatching, decisionSkippedNoAliases, decisionMutated}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		decision string
+	}{
+		{"not watching", unwatched, decisionSkippedNotWatching},
+		{"no aliases", testPod("empty"), decisionSkippedNoAliases},
+		{"mutated", testPod("default"), decisionMutated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+			before := make(map[string]float64)
+			for _, decision := range decisions {
+				before[decision] = testutil.ToFloat64(admissionDecisionsTotal.WithLabelValues(decision))
+			}
+
+			decodeReview(t, postReview(t, encodeReview(t, podReview(t, tt.pod, "uid"))))
+			for _, decision := range decisions {
+				want := before[decision]
+				if decision == tt.decision {
+					want++
+				}
+				if got := testutil.ToFloat64(admissionDecisionsTotal.WithLabelValues(decision)); got != want {
+					t.Errorf("decision %q counted %v, want %v", decision, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookMuxMutatePath(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	setupConfig(t, func(c *config) { c.MutatePath = "/hooks/inject" })
+	server := httptest.NewServer(newWebhookMux())
+	t.Cleanup(server.Close)
+	body := encodeReview(t, podReview(t, testPod("default"), "uid"))
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/hooks/inject", http.StatusOK},
+		{defaultMutatePath, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := server.Client().Post(server.URL+tt.path, "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("POST %s: %v", tt.path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestErroredResponseReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(t *testing.T) *v1.AdmissionResponse
+		code    int32
+		reason  metav1.StatusReason
+	}{
+		{
+			name: "no request",
+			respond: func(*testing.T) *v1.AdmissionResponse {
+				return mutatePods(context.Background(), &v1.AdmissionReview{})
+			},
+			code: http.StatusBadRequest, reason: reasonNoRequest,
+		},
+		{
+			name: "decode",
+			respond: func(t *testing.T) *v1.AdmissionResponse {
+				review := podReview(t, testPod("default"), "uid")
+				review.Request.Object.Raw = []byte(`"not a pod"`)
+				return mutatePods(context.Background(), review)
+			},
+			code: http.StatusBadRequest, reason: reasonDecodeFailed,
+		},
+		{
+			name: "list",
+			respond: func(t *testing.T) *v1.AdmissionResponse {
+				serviceLister = failingServiceLister{errors.New("connection refused")}
+				return mutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+			},
+			code: http.StatusInternalServerError, reason: reasonListFailed,
+		},
+		{
+			// A pod decoded from the request always encodes again, so the
+			// error is built directly.
+			name: "marshal",
+			respond: func(*testing.T) *v1.AdmissionResponse {
+				return responseErrored("uid", marshalError(errors.New("unsupported value")))
+			},
+			code: http.StatusInternalServerError, reason: reasonMarshalFailed,
+		},
+		{
+			name: "patch",
+			respond: func(*testing.T) *v1.AdmissionResponse {
+				return patchResponseFromRaw("uid", []byte("not json"), []byte("{}"))
+			},
+			code: http.StatusInternalServerError, reason: reasonPatchFailed,
+		},
+		{
+			name: "not ready",
+			respond: func(t *testing.T) *v1.AdmissionResponse {
+				readiness.cacheSynced.Store(false)
+				return mutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+			},
+			code: http.StatusServiceUnavailable, reason: reasonNotReady,
+		},
+		{
+			name: "panic",
+			respond: func(t *testing.T) *v1.AdmissionResponse {
+				saved := mutate
+				t.Cleanup(func() { mutate = saved })
+				mutate = func(context.Context, *v1.AdmissionReview) *v1.AdmissionResponse { panic("boom") }
+				return recoverMutatePods(context.Background(), podReview(t, testPod("default"), "uid"))
+			},
+			code: http.StatusInternalServerError, reason: reasonPanic,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+
+			resp := tt.respond(t)
+			if resp.Allowed || resp.Result == nil {
+				t.Fatalf("expected a rejection, got %+v", resp)
+			}
+			if resp.Result.Code != tt.code || resp.Result.Reason != tt.reason {
+				t.Fatalf("result = %d %s, want %d %s", resp.Result.Code, resp.Result.Reason, tt.code, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAliasesHash(t *testing.T) {
+	aliases := func() []corev1.HostAlias {
+		return []corev1.HostAlias{
+			{IP: "10.0.0.1", Hostnames: []string{"first.default", "first"}},
+			{IP: "10.0.0.2", Hostnames: []string{"second.default"}},
+		}
+	}
+	hash := aliasesHash(aliases())
+	if got := aliasesHash(aliases()); got != hash {
+		t.Fatalf("identical sets hash to %s and %s", hash, got)
+	}
+
+	changes := map[string]func([]corev1.HostAlias) []corev1.HostAlias{
+		"changed IP": func(a []corev1.HostAlias) []corev1.HostAlias { a[1].IP = "10.0.0.3"; return a },
+		"added hostname": func(a []corev1.HostAlias) []corev1.HostAlias {
+			a[1].Hostnames = append(a[1].Hostnames, "second")
+			return a
+		},
+		"removed alias": func(a []corev1.HostAlias) []corev1.HostAlias { return a[:1] },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			if got := aliasesHash(change(aliases())); got == hash {
+				t.Fatalf("hash %s did not change", got)
+			}
+		})
+	}
+
+	t.Run("annotation", func(t *testing.T) {
+		setupMutation(t, testService("default", "first", "10.0.0.1"))
+		conf.AliasesHash = true
+		pod := testPod("default")
+		patched := applyPatch(t, pod, mutatePods(context.Background(), podReview(t, pod, "uid")))
+		if got, want := patched.Annotations[conf.AliasesHashAnnotation], aliasesHash(patched.Spec.HostAliases); got != want {
+			t.Fatalf("hash annotation = %q, want %q", got, want)
+		}
+	})
+}
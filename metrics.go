@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	v1 "k8s.io/api/admission/v1"
+)
+
+const metricsNamespace = "host_injector"
+
+// Admission outcomes recorded by admissionRequestsTotal.
+const (
+	outcomeNoop    = "noop"
+	outcomeMutated = "mutated"
+	outcomeErrored = "errored"
+	outcomeDryRun  = "dry_run"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	admissionRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_requests_total",
+		Help:      "Number of pod admission requests handled, by outcome.",
+	}, []string{"outcome"})
+
+	admissionDecisionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_decisions_total",
+		Help:      "Number of pod admission requests handled, by the decision recorded in the audit annotation.",
+	}, []string{"decision"})
+
+	mutateDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "mutate_duration_seconds",
+		Help:      "Time spent mutating a pod admission request.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+
+	servicesSkippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "services_skipped_total",
+		Help:      "Number of services left out while building host aliases, by reason.",
+	}, []string{"reason"})
+
+	aliasBuildsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "alias_builds_in_flight",
+		Help:      "Number of host alias builds running, bounded by the concurrency limit.",
+	})
+
+	aliasBuildsQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "alias_builds_queued",
+		Help:      "Number of host alias builds waiting for a free concurrency slot.",
+	})
+
+	injectedHostAliases = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "injected_host_aliases",
+		Help:      "Number of host aliases injected by the last mutated admission.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		admissionRequestsTotal,
+		admissionDecisionsTotal,
+		mutateDurationSeconds,
+		servicesSkippedTotal,
+		aliasBuildsInFlight,
+		aliasBuildsQueued,
+		injectedHostAliases,
+	)
+}
+
+// admissionOutcome classifies a response for the admission request counter.
+// Dry-run requests are counted separately so they never show up as mutations.
+func admissionOutcome(req *v1.AdmissionRequest, resp *v1.AdmissionResponse) string {
+	switch {
+	case !resp.Allowed:
+		return outcomeErrored
+	case isDryRun(req):
+		return outcomeDryRun
+	case len(resp.Patch) > 0:
+		return outcomeMutated
+	default:
+		return outcomeNoop
+	}
+}
+
+// admissionDecision returns the decision recorded on resp, telling apart the
+// no-op paths that admissionOutcome lumps together. Errored responses carry
+// no decision.
+func admissionDecision(resp *v1.AdmissionResponse) string {
+	if decision, ok := resp.AuditAnnotations[decisionAuditAnnotation]; ok {
+		return decision
+	}
+	return outcomeErrored
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
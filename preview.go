@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// preview prints, as YAML, the host aliases a pod in the given namespace would
+// receive, and returns the process exit code.
+func preview(args []string) int {
+	fs := flag.NewFlagSet("host-injector preview", flag.ExitOnError)
+	namespace := fs.String("namespace", metav1.NamespaceDefault, "namespace of the pod to preview host aliases for")
+	if err := setup(fs, args); err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+
+	cs, err := client()
+	if err != nil {
+		slog.Error("failed to create Kubernetes client", "error", err)
+		return 1
+	}
+
+	serviceAccess.reviews = cs.AuthorizationV1().SubjectAccessReviews()
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.AdmissionTimeout)
+	defer cancel()
+	lister, err := listServicesOnce(ctx, cs)
+	if err != nil {
+		slog.Error("failed to list services", "error", err)
+		return 1
+	}
+	if endpointSlicesEnabled() {
+		if endpointSliceLister, err = listEndpointSlicesOnce(ctx, cs); err != nil {
+			slog.Error("failed to list EndpointSlices", "error", err)
+			return 1
+		}
+	}
+
+	result, err := buildHostAliases(ctx, lister, podAliasScope(&corev1.Pod{}, *namespace))
+	if err != nil {
+		slog.Error("failed to build host aliases", "error", err)
+		return 1
+	}
+	for _, warning := range result.Warnings {
+		slog.Warn(warning)
+	}
+	if conf.SplitHostAliases {
+		result.HostAliases = splitHostAliases(result.HostAliases)
+	}
+
+	out, err := yaml.Marshal(struct {
+		HostAliases []corev1.HostAlias     `json:"hostAliases"`
+		Services    []types.NamespacedName `json:"services"`
+	}{result.HostAliases, result.Services})
+	if err != nil {
+		slog.Error("failed to encode host aliases", "error", err)
+		return 1
+	}
+	fmt.Fprint(os.Stdout, string(out))
+	return 0
+}
+
+// listServicesOnce lists services a single time and serves them through a
+// lister, so one-shot commands share the webhook's alias-building code
+// without waiting on an informer.
+func listServicesOnce(ctx context.Context, cs kubernetes.Interface) (corelisters.ServiceLister, error) {
+	var services *corev1.ServiceList
+	err := retryList(ctx, "services", func() (err error) {
+		services, err = cs.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+			LabelSelector: conf.ServiceSelector,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	indexer := newNamespaceIndexer()
+	for i := range services.Items {
+		if err := indexer.Add(&services.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+	return corelisters.NewServiceLister(indexer), nil
+}
+
+// listEndpointSlicesOnce is listServicesOnce for EndpointSlices.
+func listEndpointSlicesOnce(ctx context.Context, cs kubernetes.Interface) (discoverylisters.EndpointSliceLister, error) {
+	var endpointSlices *discoveryv1.EndpointSliceList
+	err := retryList(ctx, "EndpointSlices", func() (err error) {
+		endpointSlices, err = cs.DiscoveryV1().EndpointSlices(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	indexer := newNamespaceIndexer()
+	for i := range endpointSlices.Items {
+		if err := indexer.Add(&endpointSlices.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+	return discoverylisters.NewEndpointSliceLister(indexer), nil
+}
+
+// retryList calls list, retrying failures up to the configured number of times
+// with exponential backoff. It gives up early when ctx is done and returns the
+// last error. The webhook itself reads from informer caches, which cannot fail
+// transiently, so only direct API lists are retried.
+func retryList(ctx context.Context, what string, list func() error) error {
+	delay := conf.ListRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := list()
+		if err == nil || attempt >= conf.ListRetries {
+			return err
+		}
+		slog.Debug("retrying list", "resource", what, "attempt", attempt+1, "delay", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func newNamespaceIndexer() cache.Indexer {
+	return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// failingListClient returns a fake clientset holding objects whose service
+// lists fail the first failures times, and a pointer to the number of lists.
+func failingListClient(failures int, objects ...runtime.Object) (*fake.Clientset, *int) {
+	cs := fake.NewSimpleClientset(objects...)
+	calls := 0
+	cs.PrependReactor("list", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls <= failures {
+			return true, nil, errors.New("connection refused")
+		}
+		return false, nil, nil
+	})
+	return cs, &calls
+}
+
+func TestListServicesOnceRetries(t *testing.T) {
+	saved := conf
+	t.Cleanup(func() { conf = saved })
+	conf.ListRetries = 3
+	conf.ListRetryDelay = time.Millisecond
+
+	t.Run("succeeds on the last attempt", func(t *testing.T) {
+		cs, calls := failingListClient(conf.ListRetries, testService("default", "first", "10.0.0.1"))
+		lister, err := listServicesOnce(context.Background(), cs)
+		if err != nil {
+			t.Fatalf("listing services: %v", err)
+		}
+		if *calls != conf.ListRetries+1 {
+			t.Fatalf("listed %d times, want %d", *calls, conf.ListRetries+1)
+		}
+		if services, _ := lister.List(labels.Everything()); len(services) != 1 {
+			t.Fatalf("got %d services, want 1", len(services))
+		}
+	})
+
+	t.Run("gives up after the retries", func(t *testing.T) {
+		cs, calls := failingListClient(conf.ListRetries + 1)
+		if _, err := listServicesOnce(context.Background(), cs); err == nil {
+			t.Fatal("expected an error")
+		}
+		if *calls != conf.ListRetries+1 {
+			t.Fatalf("listed %d times, want %d", *calls, conf.ListRetries+1)
+		}
+	})
+}
+
+func TestServiceSelector(t *testing.T) {
+	saved := conf
+	t.Cleanup(func() { conf = saved })
+	conf.ServiceSelector = "expose=true"
+
+	labeled := testService("default", "labeled", "10.0.0.1")
+	labeled.Labels = map[string]string{"expose": "true"}
+	other := testService("default", "other", "10.0.0.2")
+	other.Labels = map[string]string{"expose": "false"}
+	unlabeled := testService("default", "unlabeled", "10.0.0.3")
+	cs := fake.NewSimpleClientset(labeled, other, unlabeled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	informerLister, _, err := startServiceInformer(ctx, cs)
+	if err != nil {
+		t.Fatalf("starting the service informer: %v", err)
+	}
+	listLister, err := listServicesOnce(ctx, cs)
+	if err != nil {
+		t.Fatalf("listing services: %v", err)
+	}
+	for name, lister := range map[string]corelisters.ServiceLister{"informer": informerLister, "list": listLister} {
+		t.Run(name, func(t *testing.T) {
+			services, err := lister.List(labels.Everything())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(services) != 1 || services[0].Name != "labeled" {
+				t.Fatalf("services = %v, want only default/labeled", services)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+// confMu guards conf against reloads. Admissions hold the read lock while
+// they run, so each one sees a single consistent configuration.
+var confMu sync.RWMutex
+
+// copyReloadable copies the settings that can change without a restart from
+// src to dst.
+func copyReloadable(dst *config, src *config) {
+	dst.WatchLabelValues = src.WatchLabelValues
+	dst.SkipPodNamespaces = src.SkipPodNamespaces
+	dst.AllowedServiceNamespaces = src.AllowedServiceNamespaces
+	dst.DeniedServiceNamespaces = src.DeniedServiceNamespaces
+	dst.ExtraDomainSuffixes = src.ExtraDomainSuffixes
+	dst.MaxHostAliases = src.MaxHostAliases
+	dst.MaxHostnamesPerAlias = src.MaxHostnamesPerAlias
+	dst.MaxPatchBytes = src.MaxPatchBytes
+}
+
+// changedSettings returns the names of the exported settings that differ
+// between a and b.
+func changedSettings(a, b *config) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		field := va.Type().Field(i)
+		if field.IsExported() && !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, field.Name)
+		}
+	}
+	return changed
+}
+
+// reloadConfig resolves the configuration again from the config file, the
+// environment and args, and applies the reloadable settings. Other changes
+// are logged and ignored until the next restart.
+func reloadConfig(args []string) error {
+	fs := flag.NewFlagSet("host-injector serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	confMu.Lock()
+	defer confMu.Unlock()
+	// Only the reloadable fields are written, so code reading the others
+	// without the lock never races with a reload.
+	next := conf
+	copyReloadable(&next, &c)
+	if ignored := changedSettings(&next, &c); len(ignored) > 0 {
+		slog.Warn("ignoring config changes that need a restart", "settings", ignored)
+	}
+	applied := changedSettings(&conf, &next)
+	copyReloadable(&conf, &c)
+	hostAliasCache.invalidate()
+	slog.Info("reloaded config", "file", conf.configFile, "changed", applied)
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration on every SIGHUP until ctx is done.
+func reloadOnSIGHUP(ctx context.Context, args []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := reloadConfig(args); err != nil {
+				slog.Error("failed to reload config, keeping the current one", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	saved := conf
+	t.Cleanup(func() { conf = saved })
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("writing config file: %v", err)
+		}
+	}
+	args := []string{"--config", path}
+
+	write("maxHostAliases: 10\nwatchLabelValues: [a]\n")
+	c, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), args)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	conf = c
+
+	// Dropping a setting from the file restores its default.
+	write("watchLabelValues: [b]\n")
+	if err := reloadConfig(args); err != nil {
+		t.Fatalf("reloading config: %v", err)
+	}
+	if conf.MaxHostAliases != defaultConfig.MaxHostAliases {
+		t.Errorf("MaxHostAliases = %d after removing it, want the default %d", conf.MaxHostAliases, defaultConfig.MaxHostAliases)
+	}
+	if !slices.Equal(conf.WatchLabelValues, []string{"b"}) {
+		t.Errorf("WatchLabelValues = %q, want [b]", conf.WatchLabelValues)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "context"
+
+// semaphore bounds how many alias builds run at once. A nil semaphore never
+// blocks.
+type semaphore chan struct{}
+
+// aliasBuilds limits concurrent buildHostAliases calls from admissions. It is
+// set from the configuration at startup.
+var aliasBuilds semaphore
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire waits for a free slot until ctx is done.
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	aliasBuildsQueued.Inc()
+	defer aliasBuildsQueued.Dec()
+	select {
+	case s <- struct{}{}:
+		aliasBuildsInFlight.Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s == nil {
+		return
+	}
+	aliasBuildsInFlight.Dec()
+	<-s
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// slowServiceLister is a service lister whose cluster-wide lists take delay
+// and record how many were in flight at most.
+type slowServiceLister struct {
+	corelisters.ServiceLister
+	delay time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (l *slowServiceLister) List(selector labels.Selector) ([]*corev1.Service, error) {
+	l.mu.Lock()
+	l.inFlight++
+	l.maxInFlight = max(l.maxInFlight, l.inFlight)
+	l.mu.Unlock()
+
+	time.Sleep(l.delay)
+
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	return l.ServiceLister.List(selector)
+}
+
+func TestMaxConcurrentBuilds(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	conf.ServiceScope = serviceScopeCluster
+	conf.AliasCacheTTL = 0
+	conf.MaxConcurrentBuilds = 2
+	savedBuilds := aliasBuilds
+	t.Cleanup(func() { aliasBuilds = savedBuilds })
+	aliasBuilds = newSemaphore(conf.MaxConcurrentBuilds)
+	lister := &slowServiceLister{ServiceLister: serviceLister, delay: 20 * time.Millisecond}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := getHostAliasesFromServices(context.Background(), lister, aliasScope{PodNamespace: "default"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lister.maxInFlight > conf.MaxConcurrentBuilds {
+		t.Fatalf("%d builds ran at once, want at most %d", lister.maxInFlight, conf.MaxConcurrentBuilds)
+	}
+	if lister.maxInFlight < conf.MaxConcurrentBuilds {
+		t.Fatalf("at most %d builds ran at once, want the limit of %d used", lister.maxInFlight, conf.MaxConcurrentBuilds)
+	}
+}
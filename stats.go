@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/admission/v1"
+)
+
+// mutationStats counts mutate admissions for the /stats endpoint, a quick
+// alternative to scraping metrics when debugging with curl.
+type mutationStats struct {
+	total       atomic.Int64
+	mutated     atomic.Int64
+	notWatching atomic.Int64
+	noAliases   atomic.Int64
+	errored     atomic.Int64
+
+	mu            sync.Mutex
+	lastError     string
+	lastErrorTime time.Time
+}
+
+var mutateStats mutationStats
+
+// record counts a handled admission and remembers the last error.
+func (s *mutationStats) record(req *v1.AdmissionRequest, resp *v1.AdmissionResponse) {
+	s.total.Add(1)
+	switch admissionOutcome(req, resp) {
+	case outcomeMutated:
+		s.mutated.Add(1)
+	case outcomeErrored:
+		s.errored.Add(1)
+		s.mu.Lock()
+		s.lastError = resp.Result.Message
+		s.lastErrorTime = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+type statsResponse struct {
+	Total              int64      `json:"total"`
+	Mutated            int64      `json:"mutated"`
+	SkippedNotWatching int64      `json:"skippedNotWatching"`
+	SkippedNoAliases   int64      `json:"skippedNoAliases"`
+	Errored            int64      `json:"errored"`
+	LastError          string     `json:"lastError,omitempty"`
+	LastErrorTime      *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+func (s *mutationStats) snapshot() statsResponse {
+	resp := statsResponse{
+		Total:              s.total.Load(),
+		Mutated:            s.mutated.Load(),
+		SkippedNotWatching: s.notWatching.Load(),
+		SkippedNoAliases:   s.noAliases.Load(),
+		Errored:            s.errored.Load(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastError != "" {
+		t := s.lastErrorTime
+		resp.LastError, resp.LastErrorTime = s.lastError, &t
+	}
+	return resp
+}
+
+// handleStats writes the mutation counters as JSON.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(mutateStats.snapshot())
+}
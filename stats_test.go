@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+)
+
+func TestStatsCountAdmissions(t *testing.T) {
+	setupMutation(t, testService("default", "first", "10.0.0.1"))
+	server := httptest.NewServer(newMetricsMux())
+	t.Cleanup(server.Close)
+	stats := func() statsResponse {
+		t.Helper()
+		_, body := get(t, server, "/stats")
+		var s statsResponse
+		if err := json.Unmarshal([]byte(body), &s); err != nil {
+			t.Fatalf("decoding stats %q: %v", body, err)
+		}
+		return s
+	}
+
+	notWatching := testPod("default")
+	notWatching.Labels = map[string]string{"other": "label"}
+	undecodable := podReview(t, testPod("default"), "uid")
+	undecodable.Request.Object.Raw = []byte(`"not a pod"`)
+	reviews := []*v1.AdmissionReview{
+		podReview(t, testPod("default"), "uid"),
+		podReview(t, testPod("default"), "uid"),
+		podReview(t, notWatching, "uid"),
+		podReview(t, testPod("empty"), "uid"),
+		undecodable,
+	}
+
+	before := stats()
+	for _, review := range reviews {
+		decodeReview(t, postReview(t, encodeReview(t, review)))
+	}
+	after := stats()
+
+	want := statsResponse{Total: 5, Mutated: 2, SkippedNotWatching: 1, SkippedNoAliases: 1, Errored: 1}
+	got := statsResponse{
+		Total:              after.Total - before.Total,
+		Mutated:            after.Mutated - before.Mutated,
+		SkippedNotWatching: after.SkippedNotWatching - before.SkippedNotWatching,
+		SkippedNoAliases:   after.SkippedNoAliases - before.SkippedNoAliases,
+		Errored:            after.Errored - before.Errored,
+	}
+	if got != want {
+		t.Fatalf("stats changed by %+v, want %+v", got, want)
+	}
+	if after.LastError == "" || after.LastErrorTime == nil {
+		t.Fatalf("stats = %+v, want the last error", after)
+	}
+}
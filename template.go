@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// hostnameTemplateData is the data available to the hostname template.
+type hostnameTemplateData struct {
+	Name      string
+	Namespace string
+	ClusterIP string
+}
+
+// parseHostnameTemplate parses text and executes it once against sample data,
+// so unknown fields and other mistakes are reported at startup rather than
+// on every admission.
+func parseHostnameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("hostname").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := executeHostnameTemplate(tmpl, hostnameTemplateData{
+		Name:      "name",
+		Namespace: "namespace",
+		ClusterIP: "10.0.0.1",
+	}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// executeHostnameTemplate renders tmpl and splits the output into hostnames.
+// The template may produce several hostnames separated by commas or spaces.
+func executeHostnameTemplate(tmpl *template.Template, data hostnameTemplateData) ([]string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("failed to execute hostname template: %w", err)
+	}
+	return strings.FieldsFunc(b.String(), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}), nil
+}
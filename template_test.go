@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseHostnameTemplate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		tmpl, err := parseHostnameTemplate("{{.Name}}.{{.Namespace}}.internal, {{.Name}}.corp")
+		if err != nil {
+			t.Fatalf("parsing the template: %v", err)
+		}
+		got, err := executeHostnameTemplate(tmpl, hostnameTemplateData{Name: "api", Namespace: "prod", ClusterIP: "10.0.0.1"})
+		if err != nil {
+			t.Fatalf("executing the template: %v", err)
+		}
+		if want := []string{"api.prod.internal", "api.corp"}; !slices.Equal(got, want) {
+			t.Fatalf("hostnames = %q, want %q", got, want)
+		}
+	})
+
+	for _, text := range []string{"{{.Foo}}.example", "{{.Name"} {
+		t.Run(text, func(t *testing.T) {
+			if _, err := parseHostnameTemplate(text); err == nil {
+				t.Fatalf("expected an error for %q", text)
+			}
+		})
+	}
+}
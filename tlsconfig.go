@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS version such as "1.2".
+func parseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.TrimSpace(s), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return v, nil
+}
+
+// parseCipherSuites maps IANA cipher suite names, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, to their IDs. Insecure suites are
+// rejected.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// serverTLSConfig returns the TLS configuration for the webhook listener.
+// Cipher suites only apply up to TLS 1.2; TLS 1.3 suites are not
+// configurable. With a client CA configured, callers must present a
+// certificate signed by it, so only the API server can reach the webhook.
+func serverTLSConfig(certs *certReloader) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     conf.tlsMinVersion,
+		CipherSuites:   conf.tlsCipherSuites,
+		GetCertificate: certs.GetCertificate,
+	}
+	if conf.TLSClientCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(conf.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %s", conf.TLSClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
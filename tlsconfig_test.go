@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCertReloader serves a fresh self-signed key pair.
+func newTestCertReloader(t *testing.T) *certReloader {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestKeyPair(t, certFile, keyFile, "host-injector.default.svc")
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("loading the key pair: %v", err)
+	}
+	return certs
+}
+
+func TestServerTLSConfigMinVersion(t *testing.T) {
+	setupConfig(t, func(c *config) { c.TLSMinVersion = "1.2" })
+	config, err := serverTLSConfig(newTestCertReloader(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version uint16
+		wantErr bool
+	}{
+		{"TLS 1.0", tls.VersionTLS10, true},
+		{"TLS 1.1", tls.VersionTLS11, true},
+		{"TLS 1.2", tls.VersionTLS12, false},
+		{"TLS 1.3", tls.VersionTLS13, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := handshake(t, config, &tls.Config{
+				InsecureSkipVerify: true,
+				MinVersion:         tt.version,
+				MaxVersion:         tt.version,
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("handshake succeeded with version %x", state.Version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for _, s := range []string{"1.2", " TLS1.3 "} {
+		if _, err := parseTLSVersion(s); err != nil {
+			t.Errorf("parseTLSVersion(%q): %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "1.4", "SSL3", "tls1.2"} {
+		if _, err := parseTLSVersion(s); err == nil {
+			t.Errorf("parseTLSVersion(%q) accepted an unknown version", s)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || len(ids) != 1 || ids[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("parseCipherSuites = %v, %v, want the suite's ID", ids, err)
+	}
+	for _, name := range []string{"TLS_RSA_WITH_RC4_128_SHA", "TLS_NOT_A_SUITE"} {
+		if _, err := parseCipherSuites([]string{name}); err == nil {
+			t.Errorf("parseCipherSuites accepted %q", name)
+		}
+	}
+}
+
+// newClientCert returns a client certificate signed by the CA in testcerts.
+func newClientCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	ca, err := tls.LoadX509KeyPair(filepath.Join("testcerts", "ca.crt"), filepath.Join("testcerts", "ca.key"))
+	if err != nil {
+		t.Fatalf("loading the CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing the CA: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "kube-apiserver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServerTLSConfigClientCA(t *testing.T) {
+	setupConfig(t, func(c *config) { c.TLSClientCAFile = filepath.Join("testcerts", "ca.crt") })
+	config, err := serverTLSConfig(newTestCertReloader(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeTestKeyPair(t, certFile, keyFile, "intruder")
+	selfSigned, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		certs   []tls.Certificate
+		wantErr bool
+	}{
+		{"signed by the client CA", []tls.Certificate{newClientCert(t)}, false},
+		{"no client certificate", nil, true},
+		{"self-signed", []tls.Certificate{selfSigned}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := handshake(t, config, &tls.Config{InsecureSkipVerify: true, Certificates: tt.certs})
+			if tt.wantErr && err == nil {
+				t.Fatal("handshake succeeded")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conflictingHostnames returns the hostnames the pod maps to an IP that differs
+// from the service-derived aliases, sorted.
+func conflictingHostnames(podAliases, serviceAliases []corev1.HostAlias) []string {
+	// A hostname may legitimately map to one IP per family on dual-stack
+	// services, so track every expected IP.
+	expected := make(map[string][]string)
+	for _, hostAlias := range serviceAliases {
+		for _, hostname := range hostAlias.Hostnames {
+			expected[hostname] = append(expected[hostname], hostAlias.IP)
+		}
+	}
+
+	var conflicts []string
+	for _, hostAlias := range podAliases {
+		for _, hostname := range hostAlias.Hostnames {
+			ips, ok := expected[hostname]
+			if ok && !slices.Contains(ips, hostAlias.IP) {
+				conflicts = appendMissing(conflicts, hostname)
+			}
+		}
+	}
+	slices.Sort(conflicts)
+	return conflicts
+}
+
+// validatePods denies watched pods whose host aliases map a service hostname to
+// a different IP than the service's cluster IP.
+func validatePods(ctx context.Context, req *v1.AdmissionReview) *v1.AdmissionResponse {
+	if req.Request == nil {
+		return responseErrored("", errNoRequest)
+	}
+	uid := req.Request.UID
+	logger := slog.With("uid", uid, "namespace", req.Request.Namespace)
+
+	if !isPodRequest(req.Request) {
+		return responseAllowed(uid, fmt.Sprintf("Ignoring %s, only pods are validated", req.Request.Kind.Kind))
+	}
+	// The host aliases of an existing pod cannot change, so an alias that
+	// went stale after its service was recreated must not block updates
+	// such as removing a finalizer.
+	if req.Request.Operation != v1.Create {
+		return responseAllowed(uid, fmt.Sprintf("Ignoring %s, only CREATE is validated", req.Request.Operation))
+	}
+
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(req.Request.Object.Raw, &pod); err != nil {
+		return responseErrored(uid, decodeError(err))
+	}
+	logger = logger.With("pod", podDisplayName(&pod, uid))
+
+	if annotationEnabled(pod.GetAnnotations(), conf.PodDisableAnnotation) {
+		return responseAllowed(uid, "Injection disabled by annotation")
+	}
+	if podSkipped(&pod) {
+		return responseAllowed(uid, "Pod matches the skip predicate")
+	}
+	if _, watching := isWatching(&pod); !watching {
+		return responseAllowed(uid, "Pod is not watching")
+	}
+	if len(pod.Spec.HostAliases) == 0 {
+		return responseAllowed(uid, "Pod declares no host aliases")
+	}
+
+	if err := readiness.err(); err != nil {
+		return responseErrored(uid, notReadyError(err))
+	}
+
+	result, err := getHostAliasesFromServices(ctx, serviceLister, podAliasScope(&pod, req.Request.Namespace))
+	if err != nil {
+		err = fmt.Errorf("failed to get host aliases: %w", err)
+		logger.Error("failed to get host aliases", "error", err)
+		return responseErrored(uid, listError(err))
+	}
+
+	conflicts := conflictingHostnames(pod.Spec.HostAliases, result.HostAliases)
+	if len(conflicts) == 0 {
+		return responseAllowed(uid, "No conflicting host aliases")
+	}
+
+	logger.Info("denying pod with conflicting host aliases", "hostnames", conflicts)
+	return &v1.AdmissionResponse{
+		UID:     uid,
+		Allowed: false,
+		Result: &metav1.Status{
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: "host aliases conflict with service cluster IPs for: " + strings.Join(conflicts, ", "),
+		},
+	}
+}
+
+// validatePath is the URL path the validating webhook is served on.
+const validatePath = "/validate-core-v1-pod"
+
+func handleValidatePod(w http.ResponseWriter, r *http.Request) {
+	serveAdmission(w, r, validatePods)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidatePodsAdmitsUnwatchedPodsBeforeReady(t *testing.T) {
+	setupMutation(t)
+	readiness.cacheSynced.Store(false)
+	aliases := corev1.HostAlias{IP: "10.9.9.9", Hostnames: []string{"custom"}}
+
+	unwatched := testPod("default", aliases)
+	unwatched.Labels = map[string]string{"other": "label"}
+	if resp := validatePods(context.Background(), podReview(t, unwatched, "uid")); !resp.Allowed {
+		t.Fatalf("expected the unwatched pod to be allowed, got %+v", resp)
+	}
+
+	resp := validatePods(context.Background(), podReview(t, testPod("default", aliases), "uid"))
+	if resp.Allowed || resp.Result.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 rejection for the watched pod, got %+v", resp)
+	}
+}
+
+func TestValidatePods(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostAlias   corev1.HostAlias
+		operation   v1.Operation
+		wantAllowed bool
+	}{
+		{
+			name:        "compatible",
+			hostAlias:   corev1.HostAlias{IP: "10.0.0.1", Hostnames: []string{"first.default.svc"}},
+			operation:   v1.Create,
+			wantAllowed: true,
+		},
+		{
+			name:      "conflicting",
+			hostAlias: corev1.HostAlias{IP: "10.9.9.9", Hostnames: []string{"first.default.svc"}},
+			operation: v1.Create,
+		},
+		{
+			name:        "conflicting on update",
+			hostAlias:   corev1.HostAlias{IP: "10.9.9.9", Hostnames: []string{"first.default.svc"}},
+			operation:   v1.Update,
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMutation(t, testService("default", "first", "10.0.0.1"))
+
+			review := podReview(t, testPod("default", tt.hostAlias), "uid")
+			review.Request.Operation = tt.operation
+			resp := validatePods(context.Background(), review)
+			if resp.Allowed != tt.wantAllowed {
+				t.Fatalf("allowed = %t, want %t: %+v", resp.Allowed, tt.wantAllowed, resp.Result)
+			}
+			if !tt.wantAllowed && (resp.Result.Code != http.StatusForbidden || !strings.Contains(resp.Result.Message, "first.default.svc")) {
+				t.Fatalf("expected a 403 naming the conflicting hostname, got %+v", resp.Result)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestHandleVersion(t *testing.T) {
+	savedVersion, savedCommit, savedBuildDate := version, commit, buildDate
+	t.Cleanup(func() { version, commit, buildDate = savedVersion, savedCommit, savedBuildDate })
+	version, commit, buildDate = "v1.2.3", "0123abc", "2024-05-01T10:00:00Z"
+	server := httptest.NewServer(newMetricsMux())
+	t.Cleanup(server.Close)
+
+	code, body := get(t, server, "/version")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	var got versionInfo
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	want := versionInfo{Version: "v1.2.3", Commit: "0123abc", BuildDate: "2024-05-01T10:00:00Z", GoVersion: runtime.Version()}
+	if got != want {
+		t.Fatalf("version = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// genWebhookConfig prints a MutatingWebhookConfiguration matching the
+// configured server, and returns the process exit code.
+func genWebhookConfig(args []string) int {
+	fs := flag.NewFlagSet("host-injector gen-webhook-config", flag.ExitOnError)
+	caBundleFile := fs.String("ca-bundle-file", "", "PEM file with the CA that signed the webhook serving certificate (required)")
+	name := fs.String("name", "host-injector", "name of the MutatingWebhookConfiguration")
+	webhookName := fs.String("webhook-name", "pods.host-injector.io", "fully qualified name of the webhook")
+	serviceName := fs.String("service-name", "host-injector", "name of the Service in front of the webhook")
+	serviceNamespace := fs.String("service-namespace", metav1.NamespaceDefault, "namespace of the Service in front of the webhook")
+	servicePort := fs.Int("service-port", 443, "port of the Service in front of the webhook")
+	if err := setup(fs, args); err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	if *caBundleFile == "" {
+		slog.Error("--ca-bundle-file is required")
+		return 2
+	}
+	caBundle, err := os.ReadFile(*caBundleFile)
+	if err != nil {
+		slog.Error("failed to read CA bundle", "error", err)
+		return 1
+	}
+
+	out, err := yaml.Marshal(webhookConfiguration(*name, *webhookName, *serviceNamespace, *serviceName, int32(*servicePort), caBundle))
+	if err != nil {
+		slog.Error("failed to encode webhook configuration", "error", err)
+		return 1
+	}
+	fmt.Fprint(os.Stdout, string(out))
+	return 0
+}
+
+// webhookConfiguration builds a MutatingWebhookConfiguration that sends the
+// configured operations on pods carrying the watch label to the configured
+// path of the given service.
+func webhookConfiguration(name, webhookName, serviceNamespace, serviceName string, servicePort int32, caBundle []byte) *admissionregistrationv1.MutatingWebhookConfiguration {
+	reviewVersions := make([]string, 0, len(admissionReviewVersions))
+	for _, v := range admissionReviewVersions {
+		gv, _ := schema.ParseGroupVersion(v)
+		reviewVersions = append(reviewVersions, gv.Version)
+	}
+
+	// Filtering on the watch label in the API server spares the webhook a
+	// call for every other pod.
+	selector := metav1.LabelSelectorRequirement{Key: conf.WatchLabelKey, Operator: metav1.LabelSelectorOpExists}
+	if len(conf.WatchLabelValues) > 0 {
+		selector = metav1.LabelSelectorRequirement{Key: conf.WatchLabelKey, Operator: metav1.LabelSelectorOpIn, Values: conf.WatchLabelValues}
+	}
+
+	// Skipped namespaces are excluded up front as well; the server still
+	// checks them in case the configuration is edited by hand.
+	var namespaceSelector *metav1.LabelSelector
+	if len(conf.SkipPodNamespaces) > 0 {
+		namespaceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   conf.SkipPodNamespaces,
+		}}}
+	}
+
+	// A failure to build aliases admits the pod in fail-open mode, so an
+	// unreachable webhook should too.
+	failurePolicy := admissionregistrationv1.Fail
+	if conf.FailureMode == failureModeOpen {
+		failurePolicy = admissionregistrationv1.Ignore
+	}
+	sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+	path := conf.MutatePath
+	// Leave the API server a second beyond the admission timeout.
+	timeoutSeconds := int32(math.Min(math.Ceil(conf.AdmissionTimeout.Seconds())+1, 30))
+	scope := admissionregistrationv1.NamespacedScope
+
+	config := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name: webhookName,
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Namespace: serviceNamespace,
+					Name:      serviceName,
+					Path:      &path,
+					Port:      &servicePort,
+				},
+				CABundle: caBundle,
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"pods"},
+					Scope:       &scope,
+				},
+			}},
+			NamespaceSelector:       namespaceSelector,
+			ObjectSelector:          &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{selector}},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeoutSeconds,
+			AdmissionReviewVersions: reviewVersions,
+		}},
+	}
+	config.SetGroupVersionKind(admissionregistrationv1.SchemeGroupVersion.WithKind("MutatingWebhookConfiguration"))
+	return config
+}
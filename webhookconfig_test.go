@@ -0,0 +1,53 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestWebhookConfiguration(t *testing.T) {
+	setupConfig(t, func(c *config) {
+		c.MutatePath = "/inject"
+		c.WatchLabelValues = []string{"enabled"}
+	})
+	caBundle := []byte("-----BEGIN CERTIFICATE-----\ntest\n-----END CERTIFICATE-----\n")
+
+	out, err := yaml.Marshal(webhookConfiguration("host-injector", "pods.host-injector.io", "kube-system", "host-injector", 8443, caBundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got admissionregistrationv1.MutatingWebhookConfiguration
+	if err := yaml.UnmarshalStrict(out, &got); err != nil {
+		t.Fatalf("failed to parse generated configuration: %v\n%s", err, out)
+	}
+
+	if len(got.Webhooks) != 1 {
+		t.Fatalf("got %d webhooks, want 1", len(got.Webhooks))
+	}
+	webhook := got.Webhooks[0]
+	service := webhook.ClientConfig.Service
+	if service == nil || service.Namespace != "kube-system" || service.Name != "host-injector" {
+		t.Fatalf("service = %+v, want kube-system/host-injector", service)
+	}
+	if service.Path == nil || *service.Path != "/inject" {
+		t.Errorf("path = %v, want /inject", service.Path)
+	}
+	if service.Port == nil || *service.Port != 8443 {
+		t.Errorf("port = %v, want 8443", service.Port)
+	}
+	if string(webhook.ClientConfig.CABundle) != string(caBundle) {
+		t.Errorf("caBundle = %q, want %q", webhook.ClientConfig.CABundle, caBundle)
+	}
+
+	want := metav1.LabelSelectorRequirement{Key: conf.WatchLabelKey, Operator: metav1.LabelSelectorOpIn, Values: []string{"enabled"}}
+	if webhook.ObjectSelector == nil || len(webhook.ObjectSelector.MatchExpressions) != 1 {
+		t.Fatalf("objectSelector = %+v, want a single expression", webhook.ObjectSelector)
+	}
+	if expr := webhook.ObjectSelector.MatchExpressions[0]; expr.Key != want.Key || expr.Operator != want.Operator || !slices.Equal(expr.Values, want.Values) {
+		t.Errorf("objectSelector = %+v, want %+v", expr, want)
+	}
+}